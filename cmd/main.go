@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"elasticgpu.io/elastic-gpu-scheduler/pkg/controller"
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/leader"
 	"elasticgpu.io/elastic-gpu-scheduler/pkg/routes"
 	"elasticgpu.io/elastic-gpu-scheduler/pkg/scheduler"
 	"elasticgpu.io/elastic-gpu-scheduler/pkg/server"
@@ -10,23 +11,37 @@ import (
 	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils/signals"
 	"flag"
 	"github.com/julienschmidt/httprouter"
+	"k8s.io/client-go/informers"
 	"k8s.io/klog/v2"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
 	PriorityAlgorithm string
+	PolicyConfigFile  string
 	Kubeconf          string
 	ResourceMode      string
+	AssumeTTL         time.Duration
+	Workers           int
+	LeaderElect       bool
+	LeaseNamespace    string
+	LeaseName         string
 )
 
 func InitFlag() {
-	flag.StringVar(&PriorityAlgorithm, "priority", "binpack", "priority algorithm, binpack/spread")
+	flag.StringVar(&PriorityAlgorithm, "priority", "binpack", "priority algorithm, binpack/spread/fragmentation, ignored when -config is set")
+	flag.StringVar(&PolicyConfigFile, "config", "", "path to scheduling policy config file")
 	flag.StringVar(&Kubeconf, "kubeconf", "", "path to kubeconfig")
 	flag.StringVar(&ResourceMode, "mode", "", "resource mode, pgpu/qgpu/gpushare")
+	flag.IntVar(&Workers, "workers", scheduler.DefaultWorkers, "number of nodes filtered at the same time for a pod")
+	flag.DurationVar(&AssumeTTL, "assume-ttl", scheduler.DefaultAssumeTTL, "how long a filtered pod holds gpus on a node until it's bound, 0 to hold them until the pod is deleted")
+	flag.BoolVar(&LeaderElect, "leader-elect", false, "elect the replica serving pods, so that several replicas can run")
+	flag.StringVar(&LeaseNamespace, "lease-namespace", "kube-system", "namespace of the lease of the leader election, POD_NAMESPACE if set")
+	flag.StringVar(&LeaseName, "lease-name", "elastic-gpu-scheduler", "name of the lease of the leader election")
 }
 
 func main() {
@@ -41,22 +56,48 @@ func main() {
 	}
 
 	// set up priority algorithm
-	klog.Infof("priority algorithm: %s", PriorityAlgorithm)
-	var rater scheduler.Rater
-	switch PriorityAlgorithm {
-	case utils.PrioritySpread:
-		rater = &scheduler.Spread{}
-	case utils.PriorityBinPack:
-		rater = &scheduler.Binpack{}
-	default:
-		klog.Errorf("priority algorithm is not supported: %s", PriorityAlgorithm)
-		return
+	policy := scheduler.NewPolicyConfig(PriorityAlgorithm)
+	if PolicyConfigFile != "" {
+		if policy, err = scheduler.LoadPolicyConfig(PolicyConfigFile); err != nil {
+			klog.Fatalf("failed to load policy config: %v", err)
+		}
+	}
+	klog.Infof("priority policy: %+v", policy.Raters)
+	rater, err := policy.BuildRater()
+	if err != nil {
+		klog.Fatalf("failed to build rater: %v", err)
+	}
+
+	// node allocators are built from the informer caches shared with the controller
+	stopCh := signals.SetupSignalHandler()
+	informerFactory := informers.NewSharedInformerFactory(clientset, controller.ResyncPeriod)
+	lister, err := scheduler.NewClusterLister(informerFactory)
+	if err != nil {
+		klog.Fatalf("failed to set up informers: %v", err)
+	}
+	informerFactory.Start(stopCh)
+	if !lister.WaitForCacheSync(stopCh) {
+		klog.Fatal("failed to wait for informer caches to sync")
 	}
 
 	config := scheduler.ElasticSchedulerConfig{
 		Clientset:     clientset,
 		EGPUClientset: egpuClientset,
+		Lister:        lister,
 		Rater:         rater,
+		AssumeTTL:     AssumeTTL,
+		Workers:       Workers,
+		Recorder:      controller.NewEventRecorder(clientset),
+	}
+	if LeaderElect {
+		identity, err := os.Hostname()
+		if err != nil {
+			klog.Fatalf("failed to get hostname: %v", err)
+		}
+		if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+			LeaseNamespace = ns
+		}
+		config.Leader = leader.NewElector(clientset, LeaseNamespace, LeaseName, identity)
 	}
 
 	schs, err := scheduler.BuildResourceSchedulers(strings.Split(ResourceMode, ","), config)
@@ -64,14 +105,16 @@ func main() {
 		klog.Fatalf("failed to build schedulers: %s", err.Error())
 	}
 	config.RegisteredSchedulers = schs
+	if err := scheduler.RegisterNodeMetrics(schs); err != nil {
+		klog.Fatalf("failed to register metrics: %v", err)
+	}
 
 	threadness := StringToInt(os.Getenv("THREADNESS"))
 	port := os.Getenv("PORT")
 	if _, err := strconv.Atoi(port); err != nil {
 		port = "39999"
 	}
-	stopCh := signals.SetupSignalHandler()
-	schudulerController, err := controller.NewController(config, stopCh)
+	schudulerController, err := controller.NewController(config, informerFactory, stopCh)
 	if err != nil {
 		klog.Fatalf("failed to start due to %v", err)
 		return
@@ -81,6 +124,14 @@ func main() {
 	// set up kubernetes extender scheduler
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+	// standbys keep their caches warm, but only the leader serves pods
+	if config.Leader != nil {
+		go config.Leader.Run(ctx)
+	}
 	predicate := server.NewElasticGPUPredicate(ctx, config)
 	prioritize := server.NewElasticGPUPrioritize(ctx, config)
 	bind := server.NewElasticGPUBind(ctx, config)
@@ -89,10 +140,13 @@ func main() {
 	router := httprouter.New()
 	routes.AddPProf(router)
 	routes.AddVersion(router)
+	routes.AddLeader(router, config.Leader)
 	routes.AddPredicate(router, predicate)
 	routes.AddPrioritize(router, prioritize)
 	routes.AddBind(router, bind)
-	routes.AddStatus(router, schs)
+	routes.AddAllocations(router, schs)
+	routes.AddReconcileReport(router, schudulerController)
+	routes.AddMetrics(router)
 
 	klog.Infof("server starting on the port: %s", port)
 	if err := http.ListenAndServe(":"+port, router); err != nil {
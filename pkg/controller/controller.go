@@ -1,15 +1,18 @@
 package controller
 
 import (
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/metrics"
 	"elasticgpu.io/elastic-gpu-scheduler/pkg/scheduler"
 	"fmt"
 	"k8s.io/client-go/informers"
+	"sync"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
@@ -21,7 +24,9 @@ import (
 
 var (
 	KeyFunc      = clientgocache.DeletionHandlingMetaNamespaceKeyFunc
-	resyncPeriod = 30 * time.Second
+	ResyncPeriod = 30 * time.Second
+	// expirePeriod is how often expired assumptions are given back.
+	expirePeriod = 10 * time.Second
 )
 
 type Controller struct {
@@ -49,21 +54,35 @@ type Controller struct {
 
 	// nodeInformerSynced returns true if the service store has been synced at least once.
 	nodeInformerSynced clientgocache.InformerSynced
-}
 
-func NewController(config scheduler.ElasticSchedulerConfig, stopCh <-chan struct{}) (c *Controller, err error) {
-	informerFactory := informers.NewSharedInformerFactory(config.Clientset, resyncPeriod)
+	// reportLock guards report, the report of the last reconcile.
+	reportLock sync.Mutex
+	report     *ReconcileReport
+}
 
+// NewEventRecorder returns a recorder of the events of the scheduler, which the schedulers and the
+// controller share.
+func NewEventRecorder(clientset kubernetes.Interface) record.EventRecorder {
 	log.Info("Creating event broadcaster")
 	eventBroadcaster := record.NewBroadcaster()
-	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: config.Clientset.CoreV1().Events("")})
-	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "elastic-gpu-scheduler"})
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "elastic-gpu-scheduler"})
+}
+
+// NewController handles the pods and nodes of the informer factory, which the schedulers of the
+// config list them from. Events are recorded with the recorder of the config, a new one if not set.
+func NewController(config scheduler.ElasticSchedulerConfig, informerFactory informers.SharedInformerFactory, stopCh <-chan struct{}) (c *Controller, err error) {
+	recorder := config.Recorder
+	if recorder == nil {
+		recorder = NewEventRecorder(config.Clientset)
+	}
 
 	c = &Controller{
 		ElasticSchedulerConfig: config,
 		podQueue:               workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "podQueue"),
 		recorder:               recorder,
 	}
+	metrics.RegisterQueueDepth("podQueue", c.podQueue.Len)
 	// Create pod informer.
 	podInformer := informerFactory.Core().V1().Pods()
 	podInformer.Informer().AddEventHandler(clientgocache.FilteringResourceEventHandler{
@@ -95,6 +114,11 @@ func NewController(config scheduler.ElasticSchedulerConfig, stopCh <-chan struct
 
 	// Create node informer
 	nodeInformer := informerFactory.Core().V1().Nodes()
+	nodeInformer.Informer().AddEventHandler(clientgocache.ResourceEventHandlerFuncs{
+		AddFunc:    c.addNode,
+		UpdateFunc: c.updateNode,
+		DeleteFunc: c.deleteNode,
+	})
 	c.nodeLister = nodeInformer.Lister()
 	c.nodeInformerSynced = nodeInformer.Informer().HasSynced
 
@@ -134,12 +158,41 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	}
 
 	log.Info("Started workers")
+	if c.AssumeTTL > 0 {
+		go wait.Until(c.expireAssumptions, expirePeriod, stopCh)
+	}
+	go wait.Until(c.reconcile, reconcilePeriod, stopCh)
 	<-stopCh
 	log.Info("Shutting down workers")
 
 	return nil
 }
 
+// expireAssumptions gives back the options of the pods assumed longer than AssumeTTL ago, they were
+// bound to other nodes or are not going to be bound.
+func (c *Controller) expireAssumptions() {
+	before := time.Now().Add(-c.AssumeTTL)
+	for _, d := range c.schedulers() {
+		if n := d.ExpireAssumptions(before); n > 0 {
+			log.Infof("%d assumptions expired", n)
+		}
+	}
+}
+
+// schedulers returns the registered schedulers, each once although it may be registered for several
+// resources.
+func (c *Controller) schedulers() []scheduler.ResourceScheduler {
+	seen := map[scheduler.ResourceScheduler]struct{}{}
+	schedulers := make([]scheduler.ResourceScheduler, 0)
+	for _, d := range c.RegisteredSchedulers {
+		if _, ok := seen[d]; !ok {
+			seen[d] = struct{}{}
+			schedulers = append(schedulers, d)
+		}
+	}
+	return schedulers
+}
+
 // runWorker is a long-running function that will continually call the
 // processNextWorkItem function in order to read and process a message on the
 // workqueue.
@@ -298,6 +351,56 @@ func (c *Controller) deletePodFromCache(obj interface{}) {
 	c.releasePod(pod)
 }
 
+func (c *Controller) addNode(obj interface{}) {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		log.Warningf("cannot convert to *v1.Node: %v", obj)
+		return
+	}
+	c.syncNode(node)
+}
+
+func (c *Controller) updateNode(oldObj, newObj interface{}) {
+	node, ok := newObj.(*v1.Node)
+	if !ok {
+		log.Warningf("cannot convert newObj to *v1.Node: %v", newObj)
+		return
+	}
+	c.syncNode(node)
+}
+
+func (c *Controller) deleteNode(obj interface{}) {
+	var node *v1.Node
+	switch t := obj.(type) {
+	case *v1.Node:
+		node = t
+	case clientgocache.DeletedFinalStateUnknown:
+		var ok bool
+		node, ok = t.Obj.(*v1.Node)
+		if !ok {
+			log.Warningf("cannot convert to *v1.Node: %v", t.Obj)
+			return
+		}
+	default:
+		log.Warningf("cannot convert to *v1.Node: %v", t)
+		return
+	}
+
+	log.Infof("delete node %s", node.Name)
+	for _, d := range c.schedulers() {
+		d.RemoveNode(node.Name)
+	}
+}
+
+// syncNode rebuilds the state of the node in the schedulers if its GPUs changed.
+func (c *Controller) syncNode(node *v1.Node) {
+	for _, d := range c.schedulers() {
+		if err := d.UpdateNode(node); err != nil {
+			log.Errorf("update node %s failed: %v", node.Name, err)
+		}
+	}
+}
+
 func (c *Controller) releasePod(pod *v1.Pod) error {
 	d, err := scheduler.GetResourceScheduler(pod, c.RegisteredSchedulers)
 	if err != nil {
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"time"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/scheduler"
+	v1 "k8s.io/api/core/v1"
+	clientgocache "k8s.io/client-go/tools/cache"
+	log "k8s.io/klog/v2"
+)
+
+// reconcilePeriod is how often the schedulers are reconciled with the pods bound to the nodes, a
+// discrepancy is repaired once it's found by two reconciles in a row.
+var reconcilePeriod = time.Minute
+
+// ReconcileReport is the result of a reconcile of the schedulers.
+type ReconcileReport struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Discrepancies lists the discrepancies found, by resource.
+	Discrepancies map[string][]scheduler.Discrepancy `json:"discrepancies"`
+}
+
+// reconcile reconciles each scheduler with the pods bound to the nodes, and records an event for
+// each discrepancy repaired. Standbys repair their own state, but only the leader records events.
+func (c *Controller) reconcile() {
+	report := &ReconcileReport{Started: time.Now(), Discrepancies: make(map[string][]scheduler.Discrepancy)}
+	reconciled := map[scheduler.ResourceScheduler][]scheduler.Discrepancy{}
+	for name, d := range c.RegisteredSchedulers {
+		found, ok := reconciled[d]
+		if !ok {
+			found = d.Reconcile()
+			reconciled[d] = found
+			for _, f := range found {
+				c.recordDiscrepancy(f)
+			}
+		}
+		report.Discrepancies[string(name)] = found
+	}
+	report.Finished = time.Now()
+
+	c.reportLock.Lock()
+	defer c.reportLock.Unlock()
+	c.report = report
+}
+
+func (c *Controller) recordDiscrepancy(f scheduler.Discrepancy) {
+	if !f.Repaired {
+		log.V(3).Infof("Found %s on node %s, repair it if it's found again: %s", f.Reason, f.Node, f.Message)
+		return
+	}
+	log.Warningf("Repaired %s on node %s: %s", f.Reason, f.Node, f.Message)
+	if !c.Leader.IsLeader() {
+		return
+	}
+	ref := &v1.ObjectReference{Kind: "Node", Name: f.Node}
+	if f.Pod != "" {
+		ns, name, err := clientgocache.SplitMetaNamespaceKey(f.Pod)
+		if err != nil {
+			log.Warningf("invalid pod key %s: %v", f.Pod, err)
+			return
+		}
+		ref = &v1.ObjectReference{Kind: "Pod", Namespace: ns, Name: name, UID: f.PodUID}
+	}
+	c.recorder.Eventf(ref, v1.EventTypeWarning, f.Reason, "%s, the allocation of node %s is rebuilt", f.Message, f.Node)
+}
+
+// LastReconcileReport returns the report of the last reconcile, nil if none ran yet.
+func (c *Controller) LastReconcileReport() *ReconcileReport {
+	c.reportLock.Lock()
+	defer c.reportLock.Unlock()
+	return c.report
+}
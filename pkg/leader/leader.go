@@ -0,0 +1,77 @@
+package leader
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	log "k8s.io/klog/v2"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// Elector elects the replica which serves filter, prioritize and bind with a Lease lock, as each
+// replica keeps its own allocations and two of them would promise the same GPUs. The other replicas
+// are standbys, they keep their caches warm and take over once the leader is gone.
+type Elector struct {
+	identity string
+	leading  int32
+	lock     *resourcelock.LeaseLock
+}
+
+// NewElector returns the elector of the replica with the identity, over the Lease of the name in the
+// namespace.
+func NewElector(clientset kubernetes.Interface, namespace, name, identity string) *Elector {
+	return &Elector{
+		identity: identity,
+		lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Client:     clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+	}
+}
+
+// IsLeader reports whether the replica is the leader, a replica without an elector is the only one.
+func (e *Elector) IsLeader() bool {
+	return e == nil || atomic.LoadInt32(&e.leading) == 1
+}
+
+// Run takes part in the election until the context is done, the replica runs for the Lease again
+// whenever it loses it. The Lease is released when the context is done, so that a standby takes over
+// at once.
+func (e *Elector) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            e.lock,
+			ReleaseOnCancel: true,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   renewDeadline,
+			RetryPeriod:     retryPeriod,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(context.Context) {
+					log.Infof("%s is the leader", e.identity)
+					atomic.StoreInt32(&e.leading, 1)
+				},
+				OnStoppedLeading: func() {
+					if atomic.SwapInt32(&e.leading, 0) == 1 {
+						log.Warningf("%s is no longer the leader", e.identity)
+					}
+				},
+				OnNewLeader: func(identity string) {
+					if identity != e.identity {
+						log.Infof("%s is the leader, %s stands by", identity, e.identity)
+					}
+				},
+			},
+		})
+	}
+}
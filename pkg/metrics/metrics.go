@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "k8s.io/klog/v2"
+)
+
+// Namespace prefixes the names of the metrics of the scheduler.
+const Namespace = "elastic_gpu_scheduler"
+
+// Operations of the scheduler, see OperationDuration.
+const (
+	OperationFilter = "filter"
+	OperationScore  = "score"
+	OperationBind   = "bind"
+)
+
+var (
+	// OperationDuration is the latency of filtering, scoring and binding a pod, by resource.
+	OperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of filtering, scoring and binding a pod.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"resource", "operation"})
+
+	// Failures counts the failures of the operations by reason, a pod not fitting a node counts as a
+	// failure of its filter.
+	Failures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "failures_total",
+		Help:      "Failures of filtering, scoring and binding pods, by reason.",
+	}, []string{"resource", "operation", "reason"})
+)
+
+func init() {
+	prometheus.MustRegister(OperationDuration, Failures)
+}
+
+// ObserveOperation records the latency of the operation started at the given time.
+func ObserveOperation(resource, operation string, start time.Time) {
+	OperationDuration.WithLabelValues(resource, operation).Observe(time.Since(start).Seconds())
+}
+
+// Fail counts a failure of the operation.
+func Fail(resource, operation, reason string) {
+	Failures.WithLabelValues(resource, operation, reason).Inc()
+}
+
+// RegisterQueueDepth reports the depth of the named work queue.
+func RegisterQueueDepth(name string, depth func() int) {
+	gauge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Name:        "workqueue_depth",
+		Help:        "Number of items waiting in the work queue.",
+		ConstLabels: prometheus.Labels{"name": name},
+	}, func() float64 {
+		return float64(depth())
+	})
+	if err := prometheus.Register(gauge); err != nil {
+		log.Errorf("Failed to register the depth of work queue %s: %v", name, err)
+	}
+}
+
+// Register registers a collector of the state of the scheduler.
+func Register(c prometheus.Collector) error {
+	return prometheus.Register(c)
+}
+
+// Handler serves the registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
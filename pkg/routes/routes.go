@@ -2,12 +2,16 @@ package routes
 
 import (
 	"bytes"
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/controller"
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/leader"
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/metrics"
 	"elasticgpu.io/elastic-gpu-scheduler/pkg/scheduler"
 	"elasticgpu.io/elastic-gpu-scheduler/pkg/server"
 	"encoding/json"
 	"fmt"
 	"io"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -22,7 +26,13 @@ const (
 	bindPrefix       = apiPrefix + "/bind"
 	predicatesPrefix = apiPrefix + "/filter"
 	prioritiesPrefix = apiPrefix + "/priorities"
-	statusPrefix     = apiPrefix + "/status"
+	leaderPath       = apiPrefix + "/leader"
+	metricsPath      = "/metrics"
+	apiV1Prefix      = "/api/v1"
+	nodesPath        = apiV1Prefix + "/nodes"
+	nodePath         = nodesPath + "/:name"
+	podPath          = apiV1Prefix + "/pods/:namespace/:name"
+	reconcilePath    = apiV1Prefix + "/reconcile"
 )
 
 var (
@@ -178,41 +188,142 @@ func DebugLogging(h httprouter.Handle, path string) httprouter.Handle {
 	}
 }
 
+// LeaderOnly answers the requests with a retryable error while the replica is a standby,
+// kube-scheduler retries the pod until the leader serves it.
+func LeaderOnly(h httprouter.Handle, elector *leader.Elector) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if !elector.IsLeader() {
+			writeJSON(w, http.StatusServiceUnavailable, apiError("elastic-gpu-scheduler replica is a standby, retry with the leader"))
+			return
+		}
+		h(w, r, p)
+	}
+}
+
+// AddLeader serves whether the replica is the leader, it's the readiness of the replica, so that
+// requests are sent to the leader only.
+func AddLeader(router *httprouter.Router, elector *leader.Elector) {
+	router.GET(leaderPath, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if elector.IsLeader() {
+			fmt.Fprint(w, "leader")
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, "standby")
+		}
+	})
+}
+
 func AddPredicate(router *httprouter.Router, predicate *server.Predicate) {
-	router.POST(predicatesPrefix, DebugLogging(PredicateRoute(predicate), predicatesPrefix))
+	router.POST(predicatesPrefix, DebugLogging(LeaderOnly(PredicateRoute(predicate), predicate.Config.Leader), predicatesPrefix))
 }
 
 func AddPrioritize(router *httprouter.Router, prioritize *server.Prioritize) {
-	router.POST(prioritiesPrefix, DebugLogging(PrioritizeRoute(prioritize), prioritiesPrefix))
+	router.POST(prioritiesPrefix, DebugLogging(LeaderOnly(PrioritizeRoute(prioritize), prioritize.Config.Leader), prioritiesPrefix))
 }
 
 func AddBind(router *httprouter.Router, bind *server.Bind) {
 	if handle, _, _ := router.Lookup("POST", bindPrefix); handle != nil {
 		log.Warning("AddBind was called more then once")
 	} else {
-		router.POST(bindPrefix, DebugLogging(BindRoute(bind), bindPrefix))
+		router.POST(bindPrefix, DebugLogging(LeaderOnly(BindRoute(bind), bind.Config.Leader), bindPrefix))
 	}
 }
 
-func AddStatus(router *httprouter.Router, sches map[v1.ResourceName]scheduler.ResourceScheduler) {
-	router.GET(statusPrefix, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		result := make(map[string]string)
-		for k, v := range sches {
-			result[string(k)] = v.Status()
+// AddAllocations serves the allocations of the nodes and pods, see scheduler.NodeAllocation and
+// scheduler.PodAllocation. They are selected with the mode and labelSelector query parameters, the
+// label selector selects nodes.
+func AddAllocations(router *httprouter.Router, sches map[v1.ResourceName]scheduler.ResourceScheduler) {
+	router.GET(nodesPath, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		query, err := allocationQuery(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError(err.Error()))
+			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		if resultBody, err := json.Marshal(result); err != nil {
-			log.Warning(" due to ", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			errMsg := fmt.Sprintf("{'error':'%s'}", err.Error())
-			w.Write([]byte(errMsg))
-		} else {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
+		allocations, err := scheduler.ListNodeAllocations(sches, query)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError(err.Error()))
+			return
+		}
+		writeJSON(w, http.StatusOK, allocations)
+	})
+	router.GET(nodePath, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		query, err := allocationQuery(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError(err.Error()))
+			return
+		}
+		allocations, err := scheduler.GetNodeAllocations(sches, p.ByName("name"), query)
+		switch {
+		case err != nil:
+			writeJSON(w, http.StatusBadRequest, apiError(err.Error()))
+		case len(allocations) == 0:
+			writeJSON(w, http.StatusNotFound, apiError(fmt.Sprintf("node %s not found", p.ByName("name"))))
+		default:
+			writeJSON(w, http.StatusOK, allocations)
+		}
+	})
+	router.GET(podPath, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		query, err := allocationQuery(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError(err.Error()))
+			return
+		}
+		namespace, name := p.ByName("namespace"), p.ByName("name")
+		allocation, ok, err := scheduler.GetPodAllocation(sches, namespace, name, query)
+		switch {
+		case err != nil:
+			writeJSON(w, http.StatusBadRequest, apiError(err.Error()))
+		case !ok:
+			writeJSON(w, http.StatusNotFound, apiError(fmt.Sprintf("pod %s/%s not found", namespace, name)))
+		default:
+			writeJSON(w, http.StatusOK, allocation)
+		}
+	})
+}
 
-			w.Write(resultBody)
+// allocationQuery returns the query of the mode and labelSelector parameters of the request.
+func allocationQuery(r *http.Request) (scheduler.AllocationQuery, error) {
+	query := scheduler.AllocationQuery{Mode: r.URL.Query().Get("mode")}
+	if selector := r.URL.Query().Get("labelSelector"); selector != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return query, fmt.Errorf("invalid label selector %q: %v", selector, err)
 		}
+		query.Selector = parsed
+	}
+	return query, nil
+}
+
+func apiError(message string) map[string]string {
+	return map[string]string{"error": message}
+}
 
+// writeJSON writes the value in JSON with the status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	body, err := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		code = http.StatusInternalServerError
+		body, _ = json.Marshal(apiError(err.Error()))
+	}
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// AddReconcileReport serves the report of the last reconcile of the controller, see
+// controller.ReconcileReport.
+func AddReconcileReport(router *httprouter.Router, c *controller.Controller) {
+	router.GET(reconcilePath, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		report := c.LastReconcileReport()
+		if report == nil {
+			writeJSON(w, http.StatusNotFound, apiError("not reconciled yet"))
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
 	})
 }
+
+// AddMetrics serves the metrics of the scheduler in the Prometheus text format.
+func AddMetrics(router *httprouter.Router) {
+	router.Handler(http.MethodGet, metricsPath, metrics.Handler())
+}
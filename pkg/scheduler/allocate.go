@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
 	"encoding/hex"
 	"fmt"
 	v1 "k8s.io/api/core/v1"
@@ -32,29 +33,91 @@ func (d GPURequest) Hash() string {
 	return hex.EncodeToString(to(sha256.Sum256([]byte(d.String()))))[0:8]
 }
 
-func NewGPURequest(pod *v1.Pod, core v1.ResourceName, mem v1.ResourceName) GPURequest {
-	request := make([]GPUUnit, len(pod.Spec.Containers))
-	for i, c := range pod.Spec.Containers {
+// appCount returns the number of units of app containers, they come before the units of init
+// containers.
+func (d GPURequest) appCount() int {
+	for i, unit := range d {
+		if unit.Init {
+			return i
+		}
+	}
+	return len(d)
+}
+
+// NewGPURequest returns the gpu request of the pod's containers, in the order of GetPodContainers.
+// A core request of N*100+r takes N whole GPUs plus a share r of another GPU, see GPUUnit. Requests
+// which can't be satisfied as written, such as negative or fractional values, are rejected rather
+// than rounded. A container requesting the pgpu resource takes that many whole GPUs, whichever
+// resources the caller schedules, so that pgpu pods are accounted on the same devices.
+func NewGPURequest(pod *v1.Pod, core v1.ResourceName, mem v1.ResourceName) (GPURequest, error) {
+	containers := GetPodContainers(pod)
+	request := make([]GPUUnit, len(containers))
+	// an invalid constraint is reported by NodeAllocator.Assume
+	constraint, _ := NewGPUConstraint(pod)
+	for i, c := range containers {
+		init := i >= len(pod.Spec.Containers)
+		pgpu, err := GetPGPUFromContainer(&c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gpu request of container %s in pod %s/%s: %v", c.Name, pod.Namespace, pod.Name, err)
+		}
+		if pgpu > 0 {
+			if _, ok := c.Resources.Requests[core]; ok {
+				return nil, fmt.Errorf("container %s in pod %s/%s requests both %s and %s", c.Name, pod.Namespace, pod.Name, v1alpha1.ResourcePGPU, core)
+			}
+			if _, ok := c.Resources.Requests[mem]; ok {
+				return nil, fmt.Errorf("container %s in pod %s/%s requests both %s and %s", c.Name, pod.Namespace, pod.Name, v1alpha1.ResourcePGPU, mem)
+			}
+			request[i] = GPUUnit{
+				GPUCount:   pgpu,
+				SameNVLink: pod.Annotations[utils.AnnotationEGPUNVLink] == utils.NVLinkRequired,
+				Init:       init,
+				Constraint: constraint,
+			}
+			continue
+		}
+		if err := validateGPUCore(&c, core); err != nil {
+			return nil, fmt.Errorf("invalid gpu request of container %s in pod %s/%s: %v", c.Name, pod.Namespace, pod.Name, err)
+		}
+		mem, err := GetGPUMemoryFromContainer(&c, mem)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gpu request of container %s in pod %s/%s: %v", c.Name, pod.Namespace, pod.Name, err)
+		}
 		core := GetGPUCoreFromContainer(&c, core)
-		mem := GetGPUMemoryFromContainer(&c, mem)
 		klog.V(5).Infof("container %s core: %d, memory: %d", c.Name, core, mem)
 		if core == 0 && mem == 0 {
 			request[i].Core = NotNeedGPU
 			request[i].Memory = NotNeedGPU
-			continue
-		}
-		if core >= utils.GPUCoreEachCard {
-			request[i].GPUCount = core / utils.GPUCoreEachCard
+			request[i].Init = init
 			continue
 		}
 		request[i] = GPUUnit{
-			Core:   core,
-			Memory: mem,
+			Core:       core % utils.GPUCoreEachCard,
+			Memory:     mem,
+			GPUCount:   core / utils.GPUCoreEachCard,
+			Init:       init,
+			Constraint: constraint,
+		}
+		if request[i].GPUCount > 0 {
+			request[i].SameNVLink = pod.Annotations[utils.AnnotationEGPUNVLink] == utils.NVLinkRequired
 		}
 	}
 
 	klog.V(5).Infof("pod %s gpu request: %+v", pod.Name, request)
-	return request
+	return request, nil
+}
+
+func validateGPUCore(c *v1.Container, core v1.ResourceName) error {
+	val, ok := c.Resources.Requests[core]
+	if !ok {
+		return nil
+	}
+	if val.Sign() < 0 {
+		return fmt.Errorf("%s %s is negative", core, val.String())
+	}
+	if val.MilliValue()%1000 != 0 {
+		return fmt.Errorf("%s %s is not an integer", core, val.String())
+	}
+	return nil
 }
 
 type GPUOption struct {
@@ -72,10 +135,13 @@ func NewGPUOption(request GPURequest) *GPUOption {
 	return opt
 }
 
-func NewGPUOptionFromPod(pod *v1.Pod, core v1.ResourceName, mem v1.ResourceName) *GPUOption {
-	request := NewGPURequest(pod, core, mem)
+func NewGPUOptionFromPod(pod *v1.Pod, core v1.ResourceName, mem v1.ResourceName) (*GPUOption, error) {
+	request, err := NewGPURequest(pod, core, mem)
+	if err != nil {
+		return nil, err
+	}
 	option := NewGPUOption(request)
-	for i, c := range pod.Spec.Containers {
+	for i, c := range GetPodContainers(pod) {
 		if v, ok := pod.Annotations[fmt.Sprintf(utils.AnnotationEGPUContainer, c.Name)]; ok {
 			klog.V(5).Infof("container %s gpu key: %s", c.Name, v)
 			ids := strings.Split(v, ",")
@@ -89,5 +155,5 @@ func NewGPUOptionFromPod(pod *v1.Pod, core v1.ResourceName, mem v1.ResourceName)
 	}
 	klog.V(5).Infof("pod %s/%s allocated gpu: %d", pod.Namespace, pod.Name, option.Allocated)
 
-	return option
+	return option, nil
 }
@@ -0,0 +1,131 @@
+package scheduler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestNewGPURequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		core    string
+		memory  string
+		want    GPUUnit
+		wantErr string
+	}{
+		{
+			name:   "share",
+			core:   "30",
+			memory: "4",
+			want:   GPUUnit{Core: 30, Memory: 4},
+		},
+		{
+			name:   "whole gpus",
+			core:   "200",
+			memory: "0",
+			want:   GPUUnit{GPUCount: 2},
+		},
+		{
+			name:   "whole gpus plus a share",
+			core:   "150",
+			memory: "24",
+			want:   GPUUnit{Core: 50, Memory: 24, GPUCount: 1},
+		},
+		{
+			name:   "no gpu",
+			core:   "0",
+			memory: "0",
+			want:   GPUUnit{Core: NotNeedGPU, Memory: NotNeedGPU},
+		},
+		{
+			name:    "negative core",
+			core:    "-50",
+			memory:  "4",
+			wantErr: "is negative",
+		},
+		{
+			name:    "fractional core",
+			core:    "150500m",
+			memory:  "4",
+			wantErr: "is not an integer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := generatePods("request", 1)[0]
+			pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUCore] = resource.MustParse(tt.core)
+			pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUMemory] = resource.MustParse(tt.memory)
+			request, err := NewGPURequest(&pod, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error %q, got %v, %v", tt.wantErr, request, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(request, GPURequest{tt.want}) {
+				t.Fatalf("expected request %v, got %v", GPURequest{tt.want}, request)
+			}
+		})
+	}
+}
+
+func TestAssumeWholePlusShare(t *testing.T) {
+	ni, err := NewNodeAllocator(nil, newTopologyNode(2, ""), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ni.GPUs[1].Add(GPUUnit{Core: 20, Memory: 2})
+
+	pod := generatePods("mixed", 1)[0]
+	pod.UID = types.UID("mixed")
+	pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUCore] = resource.MustParse("150")
+	pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUMemory] = resource.MustParse("24")
+	if _, err := ni.Assume(&pod); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := ni.Allocate(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the free gpu taken whole, and the share on the gpu already in use
+	if !reflect.DeepEqual(ids, GPUIDs{{0, 1}}) {
+		t.Fatalf("expected gpus [[0 1]], got %v", ids)
+	}
+	if ni.GPUs[0].CoreAvailable != 0 || ni.GPUs[0].MemoryAvailable != 0 {
+		t.Fatalf("expected gpu 0 taken whole, got %+v", ni.GPUs[0])
+	}
+	// the whole gpu brings 16 of the 24 memory, the share takes the other 8
+	if ni.GPUs[1].CoreAvailable != 30 || ni.GPUs[1].MemoryAvailable != 6 {
+		t.Fatalf("expected share of 50 core and 8 memory on gpu 1, got %+v", ni.GPUs[1])
+	}
+
+	bound := GetUpdatedPodAnnotationSpec(&pod, ids)
+	if err := ni.Forget(bound); err != nil {
+		t.Fatal(err)
+	}
+	if ni.GPUs[0].CoreAvailable != 100 || ni.GPUs[1].CoreAvailable != 80 || ni.GPUs[1].MemoryAvailable != 14 {
+		t.Fatalf("expected the gpus released, got %s", ni.GPUs)
+	}
+}
+
+func TestAssumeWholeMemoryExceeded(t *testing.T) {
+	ni, err := NewNodeAllocator(nil, newTopologyNode(4, ""), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := generatePods("whole-memory", 1)[0]
+	pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUCore] = resource.MustParse("100")
+	pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUMemory] = resource.MustParse("24")
+	if _, err := ni.Assume(&pod); err == nil || !strings.Contains(err.Error(), "doesn't fit") {
+		t.Fatalf("expected memory error, got %v", err)
+	}
+}
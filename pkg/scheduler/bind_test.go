@@ -0,0 +1,191 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newBindScheduler returns a scheduler of a node with one GPU, and the pod filtered on it.
+func newBindScheduler(t *testing.T, clientset *fake.Clientset) (*GPUUnitScheduler, *v1.Pod) {
+	node := newTopologyNode(1, "")
+	config := ElasticSchedulerConfig{
+		Clientset: clientset,
+		Lister:    newTestClusterLister(t, []*v1.Node{node}, nil),
+		Rater:     &Binpack{},
+		Ledger:    NewDeviceLedger(),
+	}
+	d := &GPUUnitScheduler{BaseScheduler: newBaseScheduler(config, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)}
+	pod := newModePod("bind", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+	pod.Namespace = "default"
+	if filtered, _, err := d.Assume([]string{node.Name}, &pod); err != nil || len(filtered) != 1 {
+		t.Fatalf("expected the pod filtered on the node, got %v, %v", filtered, err)
+	}
+	return d, &pod
+}
+
+func TestBind(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	d, pod := newBindScheduler(t, clientset)
+	if _, err := clientset.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Bind("topology", pod); err != nil {
+		t.Fatal(err)
+	}
+	patched, err := clientset.CoreV1().Pods(pod.Namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Annotations["elasticgpu.io/container-main"] != "0" || patched.Labels[utils.EGPUAssumed] != "true" {
+		t.Fatalf("expected the gpu set in the pod, got annotations %v, labels %v", patched.Annotations, patched.Labels)
+	}
+	if !d.KnownPod(pod) {
+		t.Fatal("expected the pod known")
+	}
+
+	// bound again by a retry of kube-scheduler
+	if err := d.Bind("topology", pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Bind("other", pod); err == nil || !strings.Contains(err.Error(), "already bound to node topology") {
+		t.Fatalf("expected the pod bound to another node rejected, got %v", err)
+	}
+	ni := d.nodeMaps["topology"]
+	if ni.GPUs[0].CoreAvailable != 40 || ni.GPUs[0].MemoryAvailable != 8 {
+		t.Fatalf("expected the pod allocated once, got %s", ni.GPUs)
+	}
+
+	// the pod is added with its annotations when the node is rebuilt
+	if err := d.UpdateNode(newTopologyNode(2, "")); err != nil {
+		t.Fatal(err)
+	}
+	if ni := d.nodeMaps["topology"]; ni.GPUs[0].CoreAvailable != 40 || ni.GPUs[0].MemoryAvailable != 8 {
+		t.Fatalf("expected the pod kept on gpu 0, got %s", ni.GPUs)
+	}
+}
+
+func TestBindRollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		create  bool
+		reactor k8stesting.ReactionFunc
+		wantErr string
+	}{
+		{
+			name:    "pod not found",
+			wantErr: "failed to set gpus of pod default/bind-0",
+		},
+		{
+			name:   "binding failed",
+			create: true,
+			reactor: func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() == "binding" {
+					return true, nil, errors.New("node is gone")
+				}
+				return false, nil, nil
+			},
+			wantErr: "failed to bind pod default/bind-0 to node topology: node is gone",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			if tt.reactor != nil {
+				clientset.PrependReactor("create", "pods", tt.reactor)
+			}
+			d, pod := newBindScheduler(t, clientset)
+			if tt.create {
+				if _, err := clientset.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := d.Bind("topology", pod); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+			ni := d.nodeMaps["topology"]
+			if ni.GPUs[0].CoreAvailable != 100 || ni.GPUs[0].MemoryAvailable != 16 || len(ni.Pods()) != 0 {
+				t.Fatalf("expected the allocation rolled back, got %s", ni.GPUs)
+			}
+			if held := d.Ledger.HeldByOthers("topology", v1alpha1.ResourceQGPUCore); len(held) != 0 {
+				t.Fatalf("expected no gpu held, got %v", held)
+			}
+			if d.KnownPod(pod) {
+				t.Fatal("expected the pod not known")
+			}
+			if !tt.create {
+				return
+			}
+			// the gpus set on the pod before the binding failed are unset
+			live, err := clientset.CoreV1().Pods(pod.Namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := live.Annotations[fmt.Sprintf(utils.AnnotationEGPUContainer, "main")]; ok || IsAssumed(live) || live.Labels[utils.EGPUAssumed] != "" {
+				t.Fatalf("expected the gpus of the pod unset, got annotations %v, labels %v", live.Annotations, live.Labels)
+			}
+		})
+	}
+}
+
+func TestBindInProgress(t *testing.T) {
+	d, pod := newBindScheduler(t, fake.NewSimpleClientset())
+	if bound, err := d.startBind("topology", pod); bound || err != nil {
+		t.Fatalf("expected the bind started, got %v, %v", bound, err)
+	}
+	if err := d.Bind("topology", pod); err == nil || !strings.Contains(err.Error(), "is being bound") {
+		t.Fatalf("expected the second bind rejected, got %v", err)
+	}
+	d.endBind(pod)
+	if bound, err := d.startBind("topology", pod); bound || err != nil {
+		t.Fatalf("expected the bind started again, got %v, %v", bound, err)
+	}
+}
+
+func TestBindTradesAgain(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	d, pod := newBindScheduler(t, clientset)
+	if _, err := clientset.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// the node changed since the pod was filtered, its allocation is rebuilt without the assumption
+	if err := d.UpdateNode(newTopologyNode(2, "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Bind("topology", pod); err != nil {
+		t.Fatal(err)
+	}
+	if ni := d.nodeMaps["topology"]; len(ni.GPUs.GetFreeGPUs()) != 1 || ni.Assumed() != 0 {
+		t.Fatalf("expected the pod given a gpu again, got %s", ni.GPUs)
+	}
+
+	// the assumption expired and another pod took the gpus
+	clientset = fake.NewSimpleClientset()
+	d, pod = newBindScheduler(t, clientset)
+	d.ExpireAssumptions(time.Now().Add(time.Second))
+	other := newModePod("other", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+	if filtered, _, err := d.Assume([]string{"topology"}, &other); err != nil || len(filtered) != 1 {
+		t.Fatalf("expected the other pod filtered on the node, got %v, %v", filtered, err)
+	}
+	if err := d.Bind("topology", pod); err == nil || !strings.Contains(err.Error(), "no longer fits node topology") {
+		t.Fatalf("expected the pod not fit, got %v", err)
+	}
+	if len(clientset.Actions()) != 0 || d.KnownPod(pod) {
+		t.Fatalf("expected the pod not bound, got %v", clientset.Actions())
+	}
+	if ni := d.nodeMaps["topology"]; ni.Assumed() != 1 || ni.GPUs[0].CoreAvailable != 40 {
+		t.Fatalf("expected only the other pod held, got %s", ni.GPUs)
+	}
+}
@@ -0,0 +1,175 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestConcurrentScheduling drives pods through filter, score, bind, add and forget at the same time,
+// along with the controller updating nodes and expiring assumptions. Run it with -race.
+func TestConcurrentScheduling(t *testing.T) {
+	const podCount = 24
+	nodes := make([]*v1.Node, 3)
+	names := make([]string, len(nodes))
+	for i := range nodes {
+		nodes[i] = newTopologyNode(4, "")
+		nodes[i].Name = fmt.Sprintf("node-%d", i)
+		names[i] = nodes[i].Name
+	}
+	clientset := fake.NewSimpleClientset()
+	pods := make([]v1.Pod, podCount)
+	for i := range pods {
+		pods[i] = newModePod(fmt.Sprintf("pod-%d", i), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "30", "4")
+		pods[i].Namespace = "default"
+		pods[i].UID = types.UID(pods[i].Name)
+		if _, err := clientset.CoreV1().Pods(pods[i].Namespace).Create(context.Background(), &pods[i], metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := ElasticSchedulerConfig{
+		Clientset: clientset,
+		Lister:    newTestClusterLister(t, nodes, nil),
+		Rater:     &Binpack{},
+		Ledger:    NewDeviceLedger(),
+		Workers:   2,
+	}
+	d := &GPUUnitScheduler{BaseScheduler: newBaseScheduler(config, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)}
+
+	stop := make(chan struct{})
+	controller := sync.WaitGroup{}
+	controller.Add(1)
+	go func() {
+		defer controller.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			node := nodes[i%len(nodes)].DeepCopy()
+			node.Labels = map[string]string{"heartbeat": fmt.Sprint(i)}
+			if err := d.UpdateNode(node); err != nil {
+				t.Error(err)
+			}
+			d.ExpireAssumptions(time.Now().Add(-time.Hour))
+			d.NodeStatuses()
+			d.Allocations()
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := range pods {
+		wg.Add(1)
+		go func(pod *v1.Pod) {
+			defer wg.Done()
+			filtered, _, err := d.Assume(names, pod)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			d.Score(filtered, pod)
+			if len(filtered) == 0 {
+				d.ForgetPod(pod)
+				return
+			}
+			if err := d.Bind(filtered[0], pod); err != nil {
+				t.Errorf("bind pod %s: %v", pod.Name, err)
+				return
+			}
+			bound, err := clientset.CoreV1().Pods(pod.Namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			bound.Spec.NodeName = filtered[0]
+			if err := d.AddPod(bound); err != nil {
+				t.Error(err)
+			}
+			if !d.KnownPod(bound) {
+				t.Errorf("expected pod %s known", pod.Name)
+			}
+			if err := d.ForgetPod(bound); err != nil {
+				t.Error(err)
+			}
+		}(&pods[i])
+	}
+	wg.Wait()
+	close(stop)
+	controller.Wait()
+
+	for name, status := range d.NodeStatuses() {
+		if status.Assumed != 0 || len(status.Overcommitted) != 0 {
+			t.Fatalf("expected nothing left on node %s, got %+v", name, status)
+		}
+	}
+	d.eachNode(func(ni *NodeAllocator) {
+		if len(ni.GPUs.GetFreeGPUs()) != len(ni.GPUs) {
+			t.Errorf("expected the gpus of node %s free, got %s", ni.Node.Name, ni.GPUs)
+		}
+		if held := d.Ledger.HeldByOthers(ni.Node.Name, v1alpha1.ResourceQGPUCore); len(held) != 0 {
+			t.Errorf("expected no gpu of node %s held, got %v", ni.Node.Name, held)
+		}
+	})
+}
+
+// TestUpdateNodeWhileAssuming rebuilds a node while it's busy with a pod, pods are assumed on another
+// node meanwhile without waiting for the busy one. Run it with -race.
+func TestUpdateNodeWhileAssuming(t *testing.T) {
+	busy, idle := newTopologyNode(2, ""), newTopologyNode(4, "")
+	busy.Name, idle.Name = "busy", "idle"
+	config := ElasticSchedulerConfig{
+		Lister: newTestClusterLister(t, []*v1.Node{busy, idle}, nil),
+		Rater:  &Binpack{},
+		Ledger: NewDeviceLedger(),
+	}
+	d := &GPUUnitScheduler{BaseScheduler: newBaseScheduler(config, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)}
+
+	// the busy node is held as if a pod was traded on it
+	ni, err := d.lockNode(busy.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grown := newTopologyNode(4, "")
+	grown.Name = busy.Name
+	updated := make(chan error)
+	go func() {
+		updated <- d.UpdateNode(grown)
+	}()
+
+	assumed := make(chan struct{})
+	go func() {
+		defer close(assumed)
+		for i := 0; i < 8; i++ {
+			pod := newModePod(fmt.Sprintf("idle-%d", i), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "30", "4")
+			if filtered, _, err := d.Assume([]string{idle.Name}, &pod); err != nil || len(filtered) != 1 {
+				t.Errorf("expected pod %s assumed on the idle node, got %v, %v", pod.Name, filtered, err)
+			}
+		}
+	}()
+	select {
+	case <-assumed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("pods on the idle node waited for the busy node")
+	}
+	ni.lock.Unlock()
+
+	if err := <-updated; err != nil {
+		t.Fatal(err)
+	}
+	pod := newModePod("busy", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "30", "4")
+	if filtered, _, err := d.Assume([]string{busy.Name}, &pod); err != nil || len(filtered) != 1 {
+		t.Fatalf("expected the pod assumed on the rebuilt node, got %v, %v", filtered, err)
+	}
+	if status := d.NodeStatuses()[busy.Name]; len(status.GPUs) != 4 {
+		t.Fatalf("expected the busy node rebuilt with 4 gpus, got %+v", status)
+	}
+}
@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// GPUConstraint restricts the GPUs a pod can use, it's set by the elasticgpu.io/gpu-model and
+// elasticgpu.io/gpu-min-memory pod annotations.
+type GPUConstraint struct {
+	// Models lists the allowed GPU models, any model is allowed if empty.
+	Models []string
+	// MinMemory is the minimum total memory of a GPU, in utils.GPUMemoryUnit.
+	MinMemory int
+}
+
+// NewGPUConstraint returns the constraint in the pod's annotations, or nil if the pod has none.
+func NewGPUConstraint(pod *v1.Pod) (*GPUConstraint, error) {
+	constraint := &GPUConstraint{}
+	if value := pod.Annotations[utils.AnnotationEGPUModel]; value != "" {
+		for _, model := range strings.Split(value, ",") {
+			if model = strings.TrimSpace(model); model != "" {
+				constraint.Models = append(constraint.Models, model)
+			}
+		}
+	}
+	if value := pod.Annotations[utils.AnnotationEGPUMinMemory]; value != "" {
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation of pod %s/%s: %q", utils.AnnotationEGPUMinMemory, pod.Namespace, pod.Name, value)
+		}
+		memory, err := GPUMemoryFromQuantity(q)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation of pod %s/%s: %v", utils.AnnotationEGPUMinMemory, pod.Namespace, pod.Name, err)
+		}
+		constraint.MinMemory = memory
+	}
+	if len(constraint.Models) == 0 && constraint.MinMemory == 0 {
+		return nil, nil
+	}
+	return constraint, nil
+}
+
+func (c *GPUConstraint) Accepts(gpu *GPU) bool {
+	if gpu.MemoryTotal < c.MinMemory {
+		return false
+	}
+	if len(c.Models) == 0 {
+		return true
+	}
+	for _, model := range c.Models {
+		if strings.EqualFold(model, gpu.Model) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *GPUConstraint) String() string {
+	return fmt.Sprintf("models: %v, min memory: %d", c.Models, c.MinMemory)
+}
+
+// Check returns an error describing why no GPU of the node satisfies the constraint.
+func (c *GPUConstraint) Check(g GPUs) error {
+	available := make([]string, 0, len(g))
+	for _, gpu := range g {
+		if c.Accepts(gpu) {
+			return nil
+		}
+		model := gpu.Model
+		if model == "" {
+			model = "unknown"
+		}
+		available = append(available, fmt.Sprintf("%s(memory: %d)", model, gpu.MemoryTotal))
+	}
+	return fmt.Errorf("no gpu matches %s, node has %s", c, strings.Join(available, ", "))
+}
@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const mixedModelInventory = `[{"index": 0, "model": "T4", "core": 100, "memory": 16}, {"index": 1, "model": "A100", "core": 100, "memory": 40}]`
+
+func TestAssumeGPUConstraint(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		core        string
+		want        GPUIDs
+		wantErr     string
+	}{
+		{
+			name:        "model selects the card",
+			annotations: map[string]string{utils.AnnotationEGPUModel: "a100"},
+			core:        "20",
+			want:        GPUIDs{{1}},
+		},
+		{
+			name:        "one of the models",
+			annotations: map[string]string{utils.AnnotationEGPUModel: "V100, T4"},
+			core:        "20",
+			want:        GPUIDs{{0}},
+		},
+		{
+			name:        "minimum memory selects the card",
+			annotations: map[string]string{utils.AnnotationEGPUMinMemory: "32"},
+			core:        "20",
+			want:        GPUIDs{{1}},
+		},
+		{
+			name:        "whole gpu of the model",
+			annotations: map[string]string{utils.AnnotationEGPUModel: "T4"},
+			core:        "100",
+			want:        GPUIDs{{0}},
+		},
+		{
+			name:        "no card of the model",
+			annotations: map[string]string{utils.AnnotationEGPUModel: "V100"},
+			core:        "20",
+			wantErr:     "no gpu matches models: [V100], min memory: 0, node has T4(memory: 16), A100(memory: 40)",
+		},
+		{
+			name:        "not enough whole gpus of the model",
+			annotations: map[string]string{utils.AnnotationEGPUModel: "T4"},
+			core:        "200",
+			wantErr:     "no enough free gpus",
+		},
+		{
+			name:        "invalid minimum memory",
+			annotations: map[string]string{utils.AnnotationEGPUMinMemory: "sixteen"},
+			core:        "20",
+			wantErr:     "invalid elasticgpu.io/gpu-min-memory annotation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ni, err := NewNodeAllocator(nil, newInventoryNode("200", "56", mixedModelInventory), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pod := generatePods("constraint", 1)[0]
+			pod.Annotations = tt.annotations
+			pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUCore] = resource.MustParse(tt.core)
+			ids, err := ni.Assume(&pod)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error %q, got %v, %v", tt.wantErr, ids, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Fatalf("expected gpus %v, got %v", tt.want, ids)
+			}
+		})
+	}
+}
+
+func TestNodeModelLabel(t *testing.T) {
+	node := newInventoryNode("200", "56", `[{"index": 0, "core": 100, "memory": 16}, {"index": 1, "model": "A100", "core": 100, "memory": 40}]`)
+	node.Labels = map[string]string{utils.LabelEGPUModel: "T4"}
+	gpus, err := buildNodeGPUs(node, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gpus[0].Model != "T4" || gpus[1].Model != "A100" {
+		t.Fatalf("expected models T4 and A100, got %q and %q", gpus[0].Model, gpus[1].Model)
+	}
+}
@@ -0,0 +1,51 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Reasons of the events recorded on pods, see ElasticSchedulerConfig.Recorder.
+const (
+	// ReasonAssigned is recorded when the pod is bound with its GPUs.
+	ReasonAssigned = "GPUAssigned"
+	// ReasonFilteredOut is recorded when no node fits the pod.
+	ReasonFilteredOut = "GPUFilteredOut"
+	// ReasonReleased is recorded when the GPUs of a bound pod are given back.
+	ReasonReleased = "GPUReleased"
+)
+
+// recordEvent records an event on the pod. Standbys record nothing, as the leader records the same
+// events.
+func (d *BaseScheduler) recordEvent(pod *v1.Pod, eventtype, reason, messageFmt string, args ...interface{}) {
+	if d.Recorder == nil || !d.Leader.IsLeader() {
+		return
+	}
+	d.Recorder.Eventf(pod, eventtype, reason, messageFmt, args...)
+}
+
+// filterSummary sums up why the nodes failed, such as "0/3 nodes fit: 2 insufficient_gpu, 1
+// constraint".
+func filterSummary(nodes int, failures []error) string {
+	counts := make(map[string]int)
+	for _, err := range failures {
+		counts[failureReason(err)]++
+	}
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if counts[reasons[i]] != counts[reasons[j]] {
+			return counts[reasons[i]] > counts[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	for i, reason := range reasons {
+		reasons[i] = fmt.Sprintf("%d %s", counts[reason], reason)
+	}
+	return fmt.Sprintf("0/%d nodes fit: %s", nodes, strings.Join(reasons, ", "))
+}
@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/leader"
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// nextEvent returns the next event recorded, empty if there is none.
+func nextEvent(recorder *record.FakeRecorder) string {
+	select {
+	case event := <-recorder.Events:
+		return event
+	default:
+		return ""
+	}
+}
+
+func TestEvents(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	d, pod := newBindScheduler(t, clientset)
+	recorder := record.NewFakeRecorder(10)
+	d.Recorder = recorder
+	if _, err := clientset.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	score := d.Score([]string{"topology"}, pod)[0]
+	if err := d.Bind("topology", pod); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(recorder); event != fmt.Sprintf("Normal GPUAssigned Assigned GPUs [[0]] of node topology, score %d", score) {
+		t.Fatalf("expected the gpus assigned, got %q", event)
+	}
+
+	large := newModePod("large", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "100", "16")
+	if _, _, err := d.Assume([]string{"topology", "missing"}, &large); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(recorder); event != "Warning GPUFilteredOut 0/2 nodes fit: 1 insufficient_gpu, 1 node_unavailable" {
+		t.Fatalf("expected the pod filtered out, got %q", event)
+	}
+
+	bound := GetUpdatedPodAnnotationSpec(pod, GPUIDs{{0}})
+	bound.Spec.NodeName = "topology"
+	if err := d.ForgetPod(bound); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(recorder); event != "Normal GPUReleased Released GPUs [[0]] of node topology" {
+		t.Fatalf("expected the gpus released, got %q", event)
+	}
+	// nothing is held by the pod anymore
+	if err := d.ForgetPod(bound); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(recorder); event != "" {
+		t.Fatalf("expected no event, got %q", event)
+	}
+
+	// standbys record nothing
+	d.Leader = &leader.Elector{}
+	if _, _, err := d.Assume([]string{"topology"}, &large); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(recorder); event != "" {
+		t.Fatalf("expected no event on a standby, got %q", event)
+	}
+}
+
+func TestFilterSummary(t *testing.T) {
+	failures := []error{
+		withReason(reasonConstraint, nil),
+		withReason(reasonInsufficientGPU, nil),
+		withReason(reasonInsufficientGPU, nil),
+		nil,
+	}
+	summary := filterSummary(4, failures)
+	if summary != "0/4 nodes fit: 2 insufficient_gpu, 1 constraint, 1 unknown" {
+		t.Fatalf("unexpected summary %q", summary)
+	}
+}
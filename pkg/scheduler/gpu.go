@@ -6,21 +6,78 @@ import (
 	"k8s.io/klog/v2"
 )
 
+// GPUUnit is the gpu request of a container. A unit with GPUCount > 0 takes GPUCount whole GPUs,
+// plus a share of Core on one more GPU when Core > 0; its Memory is the total memory of the
+// container, the whole GPUs bring all of their memory and the share takes the rest.
 type GPUUnit struct {
 	Core     int
 	Memory   int
 	GPUCount int
+	// SameNVLink requires the GPUs of a whole GPU unit to be in the same NVLink domain.
+	SameNVLink bool
+	// Init marks the unit of an init container. Init containers run one at a time before the app
+	// containers, so they can reuse the GPUs of the app containers.
+	Init bool
+	// Constraint restricts the GPUs the unit can use, nil if any GPU can be used.
+	Constraint *GPUConstraint
 }
 
 func (g *GPUUnit) String() string {
-	return fmt.Sprintf("(core: %d, memory: %d, gpu count: %d)", g.Core, g.Memory, g.GPUCount)
+	s := fmt.Sprintf("(core: %d, memory: %d, gpu count: %d", g.Core, g.Memory, g.GPUCount)
+	if g.SameNVLink {
+		s += ", same nvlink"
+	}
+	if g.Init {
+		s += ", init"
+	}
+	if g.Constraint != nil {
+		s += ", " + g.Constraint.String()
+	}
+	return s + ")"
+}
+
+// wholePart returns the unit of the whole GPUs of the unit.
+func (g GPUUnit) wholePart() GPUUnit {
+	return GPUUnit{GPUCount: g.GPUCount, SameNVLink: g.SameNVLink, Constraint: g.Constraint}
+}
+
+// hasShare reports whether a unit with whole GPUs also needs a share of another GPU.
+func (g GPUUnit) hasShare() bool {
+	return g.GPUCount > 0 && g.Core > 0
+}
+
+// sharePart returns the unit of the GPU share of the unit, given the whole GPUs it takes.
+func (g GPUUnit) sharePart(gpus GPUs, whole []int) GPUUnit {
+	memory := g.Memory
+	for _, i := range whole {
+		memory -= gpus[i].MemoryTotal
+	}
+	if memory < 0 {
+		memory = 0
+	}
+	return GPUUnit{Core: g.Core, Memory: memory, Constraint: g.Constraint}
+}
+
+// wholeMemoryFits reports whether the memory of a unit without a share fits in its whole GPUs.
+func (g GPUUnit) wholeMemoryFits(gpus GPUs, whole []int) bool {
+	return g.hasShare() || g.sharePart(gpus, whole).Memory == 0
 }
 
 type GPU struct {
+	// Index is the device index of the GPU on the node, it's written to the container annotations.
+	Index           int
+	UUID            string
+	Model           string
 	CoreAvailable   int
 	MemoryAvailable int
 	CoreTotal       int
 	MemoryTotal     int
+	Tenants         int
+	// NVLinkGroup, PCIeSwitch and NUMANode identify the topology domains of the GPU on the node,
+	// NoTopology when unknown.
+	NVLinkGroup int
+	PCIeSwitch  int
+	NUMANode    int
 	//GPUUnits        []GPUUnit
 }
 
@@ -32,9 +89,11 @@ func (g *GPU) Add(resource GPUUnit) {
 	if resource.GPUCount > 0 {
 		g.CoreAvailable = 0
 		g.MemoryAvailable = 0
+		g.Tenants = 1
 	} else {
 		g.CoreAvailable -= resource.Core
 		g.MemoryAvailable -= resource.Memory
+		g.Tenants++
 	}
 }
 
@@ -42,13 +101,71 @@ func (g *GPU) Sub(resource GPUUnit) {
 	if resource.GPUCount > 0 {
 		g.CoreAvailable = g.CoreTotal
 		g.MemoryAvailable = g.MemoryTotal
+		g.Tenants = 0
 	} else {
 		g.CoreAvailable += resource.Core
 		g.MemoryAvailable += resource.Memory
+		if g.Tenants > 0 {
+			g.Tenants--
+		}
+	}
+}
+
+// FreeRatio returns the average of the free core and free memory fractions of the GPU, in [0, 1].
+func (g *GPU) FreeRatio() float64 {
+	return (ratio(g.CoreAvailable, g.CoreTotal) + ratio(g.MemoryAvailable, g.MemoryTotal)) / 2
+}
+
+func ratio(available, total int) float64 {
+	if total <= 0 {
+		return 0
+	}
+	if available <= 0 {
+		return 0
+	}
+	if available >= total {
+		return 1
+	}
+	return float64(available) / float64(total)
+}
+
+// gpuState is the part of a GPU that raters look at, GPUs in the same state are interchangeable
+// when searching for an option.
+type gpuState struct {
+	CoreAvailable   int
+	MemoryAvailable int
+	CoreTotal       int
+	MemoryTotal     int
+	Tenants         int
+	NVLinkGroup     int
+	PCIeSwitch      int
+	NUMANode        int
+}
+
+func (g *GPU) state() gpuState {
+	return gpuState{
+		CoreAvailable:   g.CoreAvailable,
+		MemoryAvailable: g.MemoryAvailable,
+		CoreTotal:       g.CoreTotal,
+		MemoryTotal:     g.MemoryTotal,
+		Tenants:         g.Tenants,
+		NVLinkGroup:     g.NVLinkGroup,
+		PCIeSwitch:      g.PCIeSwitch,
+		NUMANode:        g.NUMANode,
 	}
 }
 
 func (g *GPU) CanAllocate(resource GPUUnit) bool {
+	if resource.Constraint != nil && !resource.Constraint.Accepts(g) {
+		return false
+	}
+	return g.fits(resource)
+}
+
+// fits reports whether the GPU has the capacity for the unit, regardless of its constraint.
+// Options are checked by their capacity only when they are applied, as the constraint was checked
+// when they were chosen.
+func (g *GPU) fits(resource GPUUnit) bool {
 	if resource.GPUCount > 0 {
 		return g.CoreAvailable == g.CoreTotal && g.MemoryAvailable == g.MemoryTotal
 	}
@@ -62,72 +179,6 @@ func (g GPUs) String() string {
 	return string(r)
 }
 
-func (g GPUs) Trade(rater Rater, request GPURequest) (option *GPUOption, err error) {
-	var (
-		dfs     func(i int)
-		indexes = make([][]int, len(request))
-		found   = false
-	)
-	option = NewGPUOption(request)
-	dfs = func(containerIndex int) {
-		if containerIndex == len(request) {
-			found = true
-			currScore := 0
-			rateInexes := make([]int, len(indexes))
-			for i := range indexes {
-				if len(indexes[i]) == 1 {
-					rateInexes[i] = indexes[i][0]
-				} else {
-					rateInexes[i] = NotNeedRate
-				}
-			}
-			currScore = rater.Rate(g, rateInexes)
-			if option.Score > currScore {
-				return
-			}
-			for i, gpuIndex := range indexes {
-				option.Allocated[i] = gpuIndex
-			}
-			option.Score = currScore
-			return
-		}
-		klog.V(5).Infof("Start to allocate request on %d container: %+v, current gpus: %+v", containerIndex, request[containerIndex], g)
-		if request[containerIndex].GPUCount > 0 {
-			freeGPUs := g.GetFreeGPUs()
-			if len(freeGPUs) < request[containerIndex].GPUCount {
-				return
-			}
-			indexes[containerIndex] = freeGPUs[:request[containerIndex].GPUCount]
-			for _, gpuIndex := range indexes[containerIndex] {
-				g[gpuIndex].Add(request[containerIndex])
-			}
-			dfs(containerIndex + 1)
-			for _, gpuIndex := range indexes[containerIndex] {
-				g[gpuIndex].Sub(request[containerIndex])
-			}
-			return
-		}
-		for i, gpu := range g {
-			if !gpu.CanAllocate(request[containerIndex]) {
-				klog.Infof("Can't allocate request of %d container: %+v, current gpu: %+v", containerIndex, request[containerIndex], gpu)
-				continue
-			}
-			klog.Infof("Start to allocate request of %d container: %+v, current gpu: %+v", containerIndex, request[containerIndex], gpu)
-			gpu.Add(request[containerIndex])
-			indexes[containerIndex] = make([]int, 1)
-			indexes[containerIndex][0] = i
-			dfs(containerIndex + 1)
-			gpu.Sub(request[containerIndex])
-
-		}
-	}
-	dfs(0)
-	if !found {
-		return nil, fmt.Errorf("no enough resource to allocate")
-	}
-	return option, nil
-}
-
 //func (gpus GPUs) CoreUsage() float64 {
 //	coreUsed, coreAvailable := 0, 0
 //	for _, g := range gpus {
@@ -150,46 +201,148 @@ func (g GPUs) Trade(rater Rater, request GPURequest) (option *GPUOption, err err
 //	return float64(memUsed) / (float64(memUsed+memAvailable) + 0.1)
 //}
 
+// Transact takes the footprint of the option on the GPUs, nothing is taken if any GPU can't hold
+// its part.
 func (g GPUs) Transact(option *GPUOption) error {
 	klog.V(5).Infof("GPU %+v transacts %+v", g, option)
-	for i := 0; i < len(option.Allocated); i++ {
-		if option.Request[i].GPUCount > 0 {
-			for j := 0; j < len(option.Allocated[i]); j++ {
-				if !g[option.Allocated[i][j]].CanAllocate(option.Request[i]) {
-					klog.Errorf("Fail to trade option %+v on %s because the GPU's residual memory or core can't satisfy the container", option, g)
-					return fmt.Errorf("can't trade option %+v on %+v because the GPU's residual memory or core can't satisfy the container", option, g)
-				}
-				g[option.Allocated[i][j]].Add(option.Request[i])
-			}
-		} else {
-			if len(option.Allocated[i]) > 0 {
-				if !g[option.Allocated[i][0]].CanAllocate(option.Request[i]) {
-					klog.Errorf("Fail to trade option %+v on %+v because the GPU's residual memory or core can't satisfy the container", option, g)
-					return fmt.Errorf("can't trade option %+v on %+v because the GPU's residual memory or core can't satisfy the container", option, g)
-				}
-				g[option.Allocated[i][0]].Add(option.Request[i])
-			}
+	footprint := g.footprint(option)
+	for i, usage := range footprint {
+		if !usage.empty() && !g[i].canReserve(usage) {
+			klog.Errorf("Fail to trade option %+v on %+v because the GPU's residual memory or core can't satisfy the container", option, g)
+			return fmt.Errorf("can't trade option %+v on %+v because the GPU's residual memory or core can't satisfy the container", option, g)
 		}
 	}
+	for i, usage := range footprint {
+		g[i].reserve(usage)
+	}
 	return nil
 }
 
 func (g GPUs) Cancel(option *GPUOption) error {
 	klog.V(5).Infof("Cancel option %+v on GPU %+v", option, g)
-	for i := 0; i < len(option.Request); i++ {
-		if option.Request[i].GPUCount > 0 {
-			for _, gpuIndex := range option.Allocated[i] {
-				g[gpuIndex].Sub(option.Request[i])
-			}
-		} else {
-			if len(option.Allocated[i]) > 0 {
-				g[option.Allocated[i][0]].Sub(option.Request[i])
-			}
-		}
+	for i, usage := range g.footprint(option) {
+		g[i].release(usage)
 	}
 	return nil
 }
 
+// gpuUsage is what a pod holds on a GPU.
+type gpuUsage struct {
+	Core    int
+	Memory  int
+	Tenants int
+	Whole   bool
+}
+
+func (u *gpuUsage) add(unit GPUUnit) {
+	if unit.GPUCount > 0 {
+		u.Whole = true
+		return
+	}
+	u.Core += unit.Core
+	u.Memory += unit.Memory
+	u.Tenants++
+}
+
+func (u *gpuUsage) max(o gpuUsage) {
+	u.Whole = u.Whole || o.Whole
+	if o.Core > u.Core {
+		u.Core = o.Core
+	}
+	if o.Memory > u.Memory {
+		u.Memory = o.Memory
+	}
+	if o.Tenants > u.Tenants {
+		u.Tenants = o.Tenants
+	}
+}
+
+func (u gpuUsage) empty() bool {
+	return u == gpuUsage{}
+}
+
+// footprint returns what the option holds on each GPU. App containers run together and their
+// usages add up, while init containers run one at a time before them, so a GPU holds the largest of
+// the usage of the app containers and the usage of each init container.
+func (g GPUs) footprint(option *GPUOption) []gpuUsage {
+	footprint := make([]gpuUsage, len(g))
+	for i, unit := range option.Request {
+		if unit.Init || i >= len(option.Allocated) {
+			continue
+		}
+		allocated, units := g.unitsOf(unit, option.Allocated[i])
+		for j, gpuIndex := range allocated {
+			footprint[gpuIndex].add(units[j])
+		}
+	}
+	for i, unit := range option.Request {
+		if !unit.Init || i >= len(option.Allocated) {
+			continue
+		}
+		allocated, units := g.unitsOf(unit, option.Allocated[i])
+		for j, gpuIndex := range allocated {
+			usage := gpuUsage{}
+			usage.add(units[j])
+			footprint[gpuIndex].max(usage)
+		}
+	}
+	return footprint
+}
+
+func (g *GPU) canReserve(usage gpuUsage) bool {
+	if usage.Whole {
+		return g.fits(GPUUnit{GPUCount: 1})
+	}
+	return g.CoreAvailable >= usage.Core && g.MemoryAvailable >= usage.Memory
+}
+
+func (g *GPU) reserve(usage gpuUsage) {
+	if usage.Whole {
+		g.Add(GPUUnit{GPUCount: 1})
+		return
+	}
+	g.CoreAvailable -= usage.Core
+	g.MemoryAvailable -= usage.Memory
+	g.Tenants += usage.Tenants
+}
+
+func (g *GPU) release(usage gpuUsage) {
+	if usage.Whole {
+		g.Sub(GPUUnit{GPUCount: 1})
+		return
+	}
+	g.CoreAvailable += usage.Core
+	g.MemoryAvailable += usage.Memory
+	if g.Tenants -= usage.Tenants; g.Tenants < 0 {
+		g.Tenants = 0
+	}
+}
+
+// unitsOf returns the GPUs allocated to a container and the unit taken on each of them. A unit
+// with whole GPUs takes its first GPUCount GPUs whole and its share on the GPU after them.
+func (g GPUs) unitsOf(unit GPUUnit, allocated []int) ([]int, []GPUUnit) {
+	if unit.GPUCount == 0 {
+		// containers of other resources have annotations too, but nothing to take here
+		if len(allocated) == 0 || allocated[0] == NotNeedGPU || (unit.Core == NotNeedGPU && unit.Memory == NotNeedGPU) {
+			return nil, nil
+		}
+		return allocated[:1], []GPUUnit{unit}
+	}
+	whole := allocated
+	if len(whole) > unit.GPUCount {
+		whole = whole[:unit.GPUCount]
+	}
+	units := make([]GPUUnit, 0, len(allocated))
+	for range whole {
+		units = append(units, unit.wholePart())
+	}
+	if unit.hasShare() && len(allocated) > len(whole) {
+		units = append(units, unit.sharePart(g, whole))
+		return allocated[:len(whole)+1], units
+	}
+	return whole, units
+}
+
 func (g GPUs) GetFreeGPUs() []int {
 	indexes := make([]int, 0)
 	for i := 0; i < len(g); i++ {
@@ -200,3 +353,26 @@ func (g GPUs) GetFreeGPUs() []int {
 
 	return indexes
 }
+
+// allocatableGPUs returns the GPUs which can hold the unit.
+func (g GPUs) allocatableGPUs(unit GPUUnit) []int {
+	indexes := make([]int, 0)
+	for i := range g {
+		if g[i].CanAllocate(unit) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// FreeRatio returns the average of the free core and free memory fractions of all GPUs on the node, in [0, 1].
+func (g GPUs) FreeRatio() float64 {
+	coreAvailable, coreTotal, memAvailable, memTotal := 0, 0, 0, 0
+	for _, gpu := range g {
+		coreAvailable += gpu.CoreAvailable
+		coreTotal += gpu.CoreTotal
+		memAvailable += gpu.MemoryAvailable
+		memTotal += gpu.MemoryTotal
+	}
+	return (ratio(coreAvailable, coreTotal) + ratio(memAvailable, memTotal)) / 2
+}
@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"fmt"
+)
+
+// placeInit places the init containers of an option whose app containers are placed. An init
+// container is placed where the pod already holds enough for it if it can, so that it doesn't add
+// to the footprint of the pod, otherwise its whole GPUs are chosen by their topology and its share
+// goes to the GPU the rater prefers. Init containers don't change the score of the option.
+func (g GPUs) placeInit(rater Rater, option *GPUOption) error {
+	for i, unit := range option.Request {
+		if !unit.Init {
+			continue
+		}
+		if unit.Core == NotNeedGPU && unit.Memory == NotNeedGPU {
+			option.Allocated[i] = []int{NotNeedGPU}
+			continue
+		}
+		footprint := g.footprint(option)
+		var whole []int
+		if unit.GPUCount > 0 {
+			selected, err := g.selectInitWholeGPUs(unit, footprint)
+			if err != nil {
+				return fmt.Errorf("init container %s: %v", unit.String(), err)
+			}
+			if !unit.wholeMemoryFits(g, selected) {
+				return fmt.Errorf("init container %s: memory %d doesn't fit in %d whole gpus", unit.String(), unit.Memory, unit.GPUCount)
+			}
+			if !unit.hasShare() {
+				option.Allocated[i] = selected
+				continue
+			}
+			whole, unit = selected, unit.sharePart(g, selected)
+		}
+		share, ok := g.selectInitShare(rater, unit, footprint, whole)
+		if !ok {
+			return fmt.Errorf("no gpu can hold the share of init container %s", option.Request[i].String())
+		}
+		option.Allocated[i] = append(whole, share)
+	}
+	return nil
+}
+
+// selectInitWholeGPUs chooses the whole GPUs of an init container, out of the GPUs the pod already
+// holds whole if there are enough of them.
+func (g GPUs) selectInitWholeGPUs(unit GPUUnit, footprint []gpuUsage) ([]int, error) {
+	wholeUnit := unit.wholePart()
+	owned, free := make([]int, 0), make([]int, 0)
+	for i, gpu := range g {
+		if footprint[i].Whole && (unit.Constraint == nil || unit.Constraint.Accepts(gpu)) {
+			owned = append(owned, i)
+			free = append(free, i)
+		} else if gpu.CanAllocate(wholeUnit) {
+			free = append(free, i)
+		}
+	}
+	if len(owned) >= unit.GPUCount {
+		if selected, err := g.SelectWholeGPUs(owned, unit.GPUCount, unit.SameNVLink); err == nil {
+			return selected, nil
+		}
+	}
+	return g.SelectWholeGPUs(free, unit.GPUCount, unit.SameNVLink)
+}
+
+// selectInitShare chooses the GPU of the share of an init container, other than the whole GPUs of
+// the container. The first GPU where the pod holds enough for the share is chosen, otherwise the
+// best rated one with the pod and the rest of the share on it.
+func (g GPUs) selectInitShare(rater Rater, unit GPUUnit, footprint []gpuUsage, whole []int) (int, bool) {
+	type candidate struct {
+		index int
+		extra gpuUsage
+	}
+	candidates := make([]candidate, 0, len(g))
+	for i, gpu := range g {
+		if containsInt(whole, i) || (unit.Constraint != nil && !unit.Constraint.Accepts(gpu)) {
+			continue
+		}
+		held := footprint[i]
+		if held.Whole {
+			if gpu.CoreTotal >= unit.Core && gpu.MemoryTotal >= unit.Memory {
+				return i, true
+			}
+			continue
+		}
+		if gpu.CoreAvailable < unit.Core || gpu.MemoryAvailable < unit.Memory {
+			continue
+		}
+		extra := gpuUsage{}
+		if unit.Core > held.Core {
+			extra.Core = unit.Core - held.Core
+		}
+		if unit.Memory > held.Memory {
+			extra.Memory = unit.Memory - held.Memory
+		}
+		if held.Tenants == 0 {
+			extra.Tenants = 1
+		}
+		if extra.Core == 0 && extra.Memory == 0 {
+			return i, true
+		}
+		candidates = append(candidates, candidate{index: i, extra: extra})
+	}
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	for i, usage := range footprint {
+		g[i].reserve(usage)
+	}
+	defer func() {
+		for i, usage := range footprint {
+			g[i].release(usage)
+		}
+	}()
+	best, bestScore := candidates[0].index, 0
+	for n, c := range candidates {
+		g[c.index].reserve(c.extra)
+		score := rater.Rate(g, []int{c.index})
+		g[c.index].release(c.extra)
+		if n == 0 || score > bestScore {
+			best, bestScore = c.index, score
+		}
+	}
+	return best, true
+}
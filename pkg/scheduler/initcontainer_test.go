@@ -0,0 +1,123 @@
+package scheduler
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newInitPod(name string, appCore, appMemory, initCore, initMemory string) v1.Pod {
+	pod := generatePods(name, 1)[0]
+	pod.UID = types.UID(name)
+	pod.Spec.Containers[0].Name = "app"
+	pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUCore] = resource.MustParse(appCore)
+	pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUMemory] = resource.MustParse(appMemory)
+	pod.Spec.InitContainers = []v1.Container{{
+		Name: "warmup",
+		Resources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{
+				v1alpha1.ResourceGPUCore:   resource.MustParse(initCore),
+				v1alpha1.ResourceGPUMemory: resource.MustParse(initMemory),
+			},
+		},
+	}}
+	return pod
+}
+
+func TestNewGPURequestInitContainers(t *testing.T) {
+	pod := newInitPod("init-request", "30", "4", "60", "8")
+	request, err := NewGPURequest(&pod, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GPURequest{{Core: 30, Memory: 4}, {Core: 60, Memory: 8, Init: true}}
+	if !reflect.DeepEqual(request, want) {
+		t.Fatalf("expected request %v, got %v", want, request)
+	}
+	if request.appCount() != 1 {
+		t.Fatalf("expected 1 app container, got %d", request.appCount())
+	}
+}
+
+func TestAssumeInitContainerReusesAppGPU(t *testing.T) {
+	tests := []struct {
+		name                 string
+		initCore, initMemory string
+		wantCore, wantMemory int
+	}{
+		{
+			name:     "init container smaller than the app container",
+			initCore: "20", initMemory: "2",
+			wantCore: 70, wantMemory: 12,
+		},
+		{
+			name:     "init container larger than the app container",
+			initCore: "60", initMemory: "8",
+			wantCore: 40, wantMemory: 8,
+		},
+		{
+			name:     "whole gpu init container",
+			initCore: "100", initMemory: "0",
+			wantCore: 0, wantMemory: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a single gpu, so the pod only fits if the init container reuses the gpu of the app
+			// container instead of adding to it
+			ni, err := NewNodeAllocator(nil, newTopologyNode(1, ""), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pod := newInitPod("init", "30", "4", tt.initCore, tt.initMemory)
+			if _, err := ni.Assume(&pod); err != nil {
+				t.Fatal(err)
+			}
+			ids, err := ni.Allocate(&pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ids, GPUIDs{{0}, {0}}) {
+				t.Fatalf("expected both containers on gpu 0, got %v", ids)
+			}
+			if ni.GPUs[0].CoreAvailable != tt.wantCore || ni.GPUs[0].MemoryAvailable != tt.wantMemory || ni.GPUs[0].Tenants != 1 {
+				t.Fatalf("expected core %d and memory %d left, got %+v", tt.wantCore, tt.wantMemory, ni.GPUs[0])
+			}
+
+			bound := GetUpdatedPodAnnotationSpec(&pod, ids)
+			for _, name := range []string{"app", "warmup"} {
+				if v := bound.Annotations[fmt.Sprintf(utils.AnnotationEGPUContainer, name)]; v != "0" {
+					t.Fatalf("expected annotation 0 of container %s, got %q", name, v)
+				}
+			}
+			if err := ni.Forget(bound); err != nil {
+				t.Fatal(err)
+			}
+			if ni.GPUs[0].CoreAvailable != 100 || ni.GPUs[0].MemoryAvailable != 16 || ni.GPUs[0].Tenants != 0 {
+				t.Fatalf("expected gpu 0 released, got %+v", ni.GPUs[0])
+			}
+		})
+	}
+}
+
+func TestAssumeInitContainerExceedsNode(t *testing.T) {
+	ni, err := NewNodeAllocator(nil, newTopologyNode(2, ""), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ni.GPUs[0].Add(GPUUnit{Core: 50, Memory: 8})
+	ni.GPUs[1].Add(GPUUnit{Core: 50, Memory: 8})
+
+	pod := newInitPod("init-large", "30", "4", "60", "4")
+	if _, err := ni.Assume(&pod); err == nil || !strings.Contains(err.Error(), "init container") {
+		t.Fatalf("expected init container error, got %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// GPUDevice is a GPU of a node as reported by the agent in the elasticgpu.io/gpu-inventory node
+// annotation, e.g.
+//
+//	[{"index": 0, "uuid": "GPU-8f6d...", "model": "T4", "core": 100, "memory": 16}, {"index": 2, "uuid": "GPU-1c0b...", "model": "A100", "core": 50, "memory": 40}]
+//
+// Core and Memory are the capacities schedulable on the device, in the units of the gpu core
+// resource and in utils.GPUMemoryUnit, so partially reserved cards report less than their full size.
+type GPUDevice struct {
+	Index  int    `json:"index"`
+	UUID   string `json:"uuid,omitempty"`
+	Model  string `json:"model,omitempty"`
+	Core   int    `json:"core"`
+	Memory int    `json:"memory"`
+}
+
+// GetNodeGPUInventory returns the devices in the node's inventory annotation, or nil if the node
+// doesn't have one.
+func GetNodeGPUInventory(node *v1.Node) ([]GPUDevice, error) {
+	value, ok := node.Annotations[utils.AnnotationEGPUInventory]
+	if !ok || value == "" {
+		return nil, nil
+	}
+	devices := make([]GPUDevice, 0)
+	if err := json.Unmarshal([]byte(value), &devices); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation of node %s: %v", utils.AnnotationEGPUInventory, node.Name, err)
+	}
+	return devices, nil
+}
+
+// NewGPUsFromDevices builds the GPUs of a node from its devices, ordered by device index.
+func NewGPUsFromDevices(devices []GPUDevice) (GPUs, error) {
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no gpu device")
+	}
+	devices = append([]GPUDevice(nil), devices...)
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Index < devices[j].Index })
+	gpus := make(GPUs, 0, len(devices))
+	for i, d := range devices {
+		if d.Index < 0 {
+			return nil, fmt.Errorf("invalid index %d of gpu device %s", d.Index, d.UUID)
+		}
+		if i > 0 && devices[i-1].Index == d.Index {
+			return nil, fmt.Errorf("duplicated gpu device index %d", d.Index)
+		}
+		if d.Core <= 0 || d.Core > utils.GPUCoreEachCard || d.Memory < 0 {
+			return nil, fmt.Errorf("invalid capacity of gpu device %d: core %d, memory %d", d.Index, d.Core, d.Memory)
+		}
+		gpus = append(gpus, newDeviceGPU(d))
+	}
+	return gpus, nil
+}
+
+// NewEvenGPUs splits the gpu core and memory of a node evenly over its GPUs, it's used for nodes
+// without an inventory.
+func NewEvenGPUs(core, memory int) GPUs {
+	gpuCount := core / utils.GPUCoreEachCard
+	gpus := make(GPUs, 0, gpuCount)
+	for i := 0; i < gpuCount; i++ {
+		gpus = append(gpus, newDeviceGPU(GPUDevice{
+			Index:  i,
+			Core:   utils.GPUCoreEachCard,
+			Memory: memory / gpuCount,
+		}))
+	}
+	return gpus
+}
+
+func newDeviceGPU(d GPUDevice) *GPU {
+	return &GPU{
+		Index:           d.Index,
+		UUID:            d.UUID,
+		Model:           d.Model,
+		CoreAvailable:   d.Core,
+		CoreTotal:       d.Core,
+		MemoryAvailable: d.Memory,
+		MemoryTotal:     d.Memory,
+		NVLinkGroup:     NoTopology,
+		PCIeSwitch:      NoTopology,
+		NUMANode:        NoTopology,
+	}
+}
+
+// buildNodeGPUs builds the GPUs of the node from its inventory, falling back to an even split of
+// the node's allocatable resources when the node has no valid inventory. GPUs without a model in
+// the inventory take the model in the node's elasticgpu.io/gpu-model label.
+func buildNodeGPUs(node *v1.Node, core v1.ResourceName, mem v1.ResourceName) (GPUs, error) {
+	gpus, err := buildInventoryGPUs(node, core, mem)
+	if err != nil {
+		return nil, err
+	}
+	if model := node.Labels[utils.LabelEGPUModel]; model != "" {
+		for _, gpu := range gpus {
+			if gpu.Model == "" {
+				gpu.Model = model
+			}
+		}
+	}
+	return gpus, nil
+}
+
+func buildInventoryGPUs(node *v1.Node, core v1.ResourceName, mem v1.ResourceName) (GPUs, error) {
+	coreAvail := node.Status.Allocatable[core]
+	memAvail, err := GPUMemoryFromQuantity(node.Status.Allocatable[mem])
+	if err != nil {
+		return nil, fmt.Errorf("invalid allocatable %s of node %s: %v", mem, node.Name, err)
+	}
+
+	devices, err := GetNodeGPUInventory(node)
+	if err != nil {
+		klog.Errorf("Ignore gpu inventory of node %s: %v", node.Name, err)
+	}
+	if len(devices) > 0 {
+		gpus, err := NewGPUsFromDevices(devices)
+		if err == nil {
+			coreTotal, memTotal := 0, 0
+			for _, gpu := range gpus {
+				coreTotal += gpu.CoreTotal
+				memTotal += gpu.MemoryTotal
+			}
+			if int64(coreTotal) != coreAvail.Value() || memTotal != memAvail {
+				klog.Warningf("GPU inventory of node %s has core %d and memory %d, but allocatable is core %d and memory %d",
+					node.Name, coreTotal, memTotal, coreAvail.Value(), memAvail)
+			}
+			return gpus, nil
+		}
+		klog.Errorf("Ignore gpu inventory of node %s: %v", node.Name, err)
+	}
+
+	if coreAvail.Value() < utils.GPUCoreEachCard {
+		// nodes only for pgpu pods advertise the number of GPUs, pgpu pods are scheduled with the
+		// gpushare resources, see NewPGPUScheduler
+		if pgpu := node.Status.Allocatable[v1alpha1.ResourcePGPU]; core == v1alpha1.ResourceGPUCore && pgpu.Value() > 0 {
+			return NewEvenGPUs(int(pgpu.Value())*utils.GPUCoreEachCard, memAvail), nil
+		}
+		return nil, fmt.Errorf("no gpu available on node %s", node.Name)
+	}
+	return NewEvenGPUs(int(coreAvail.Value()), memAvail), nil
+}
+
+// Position returns the position of the GPU with the device index.
+func (g GPUs) Position(index int) (int, bool) {
+	for i, gpu := range g {
+		if gpu.Index == index {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// DeviceIndexes maps the positions in ids to device indexes.
+func (g GPUs) DeviceIndexes(ids GPUIDs) GPUIDs {
+	indexes := make(GPUIDs, len(ids))
+	for i := range ids {
+		indexes[i] = make([]int, len(ids[i]))
+		for j, position := range ids[i] {
+			if position < 0 || position >= len(g) {
+				indexes[i][j] = position
+				continue
+			}
+			indexes[i][j] = g[position].Index
+		}
+	}
+	return indexes
+}
+
+// Positions maps the device indexes in ids to positions.
+func (g GPUs) Positions(ids GPUIDs) (GPUIDs, error) {
+	positions := make(GPUIDs, len(ids))
+	for i := range ids {
+		positions[i] = make([]int, len(ids[i]))
+		for j, index := range ids[i] {
+			if index < 0 {
+				positions[i][j] = index
+				continue
+			}
+			position, ok := g.Position(index)
+			if !ok {
+				return nil, fmt.Errorf("gpu device %d not found", index)
+			}
+			positions[i][j] = position
+		}
+	}
+	return positions, nil
+}
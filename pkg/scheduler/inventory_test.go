@@ -0,0 +1,141 @@
+package scheduler
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newInventoryNode(core, memory string, inventory string) *v1.Node {
+	node := &v1.Node{
+		Status: v1.NodeStatus{
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1alpha1.ResourceGPUCore:   resource.MustParse(core),
+				v1alpha1.ResourceGPUMemory: resource.MustParse(memory),
+			},
+		},
+	}
+	node.Name = "inventory"
+	if inventory != "" {
+		node.Annotations = map[string]string{utils.AnnotationEGPUInventory: inventory}
+	}
+	return node
+}
+
+func TestBuildNodeGPUs(t *testing.T) {
+	tests := []struct {
+		name      string
+		core      string
+		memory    string
+		inventory string
+		want      []GPUDevice
+		wantErr   bool
+	}{
+		{
+			name:   "even split without inventory",
+			core:   "200",
+			memory: "32",
+			want:   []GPUDevice{{Index: 0, Core: 100, Memory: 16}, {Index: 1, Core: 100, Memory: 16}},
+		},
+		{
+			name:      "mixed cards ordered by index",
+			core:      "150",
+			memory:    "56",
+			inventory: `[{"index": 2, "uuid": "GPU-b", "core": 50, "memory": 40}, {"index": 0, "uuid": "GPU-a", "core": 100, "memory": 16}]`,
+			want:      []GPUDevice{{Index: 0, UUID: "GPU-a", Core: 100, Memory: 16}, {Index: 2, UUID: "GPU-b", Core: 50, Memory: 40}},
+		},
+		{
+			name:      "duplicated index falls back to even split",
+			core:      "200",
+			memory:    "32",
+			inventory: `[{"index": 0, "core": 100, "memory": 16}, {"index": 0, "core": 100, "memory": 16}]`,
+			want:      []GPUDevice{{Index: 0, Core: 100, Memory: 16}, {Index: 1, Core: 100, Memory: 16}},
+		},
+		{
+			name:      "malformed inventory falls back to even split",
+			core:      "100",
+			memory:    "16",
+			inventory: `{"index": 0}`,
+			want:      []GPUDevice{{Index: 0, Core: 100, Memory: 16}},
+		},
+		{
+			name:    "no gpu",
+			core:    "0",
+			memory:  "0",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gpus, err := buildNodeGPUs(newInventoryNode(tt.core, tt.memory, tt.inventory), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %s", gpus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]GPUDevice, len(gpus))
+			for i, gpu := range gpus {
+				got[i] = GPUDevice{Index: gpu.Index, UUID: gpu.UUID, Core: gpu.CoreTotal, Memory: gpu.MemoryTotal}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected devices %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHeterogeneousAllocation(t *testing.T) {
+	node := newInventoryNode("150", "56", `[{"index": 0, "core": 100, "memory": 16}, {"index": 2, "core": 50, "memory": 40}]`)
+	ni, err := NewNodeAllocator(nil, node, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the second card has enough memory
+	pod := generatePods("big-memory", 1)[0]
+	pod.UID = types.UID("big-memory")
+	pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUCore] = resource.MustParse("30")
+	pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUMemory] = resource.MustParse("24")
+	if _, err := ni.Assume(&pod); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := ni.Allocate(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, GPUIDs{{2}}) {
+		t.Fatalf("expected device 2, got %v", ids)
+	}
+	if ni.GPUs[1].CoreAvailable != 20 || ni.GPUs[1].MemoryAvailable != 16 {
+		t.Fatalf("unexpected gpu state %+v", ni.GPUs[1])
+	}
+
+	// the annotation written at bind is read back as the same device
+	bound := GetUpdatedPodAnnotationSpec(&pod, ids)
+	if v := bound.Annotations[fmt.Sprintf(utils.AnnotationEGPUContainer, bound.Spec.Containers[0].Name)]; v != "2" {
+		t.Fatalf("expected annotation 2, got %q", v)
+	}
+	if err := ni.Forget(bound); err != nil {
+		t.Fatal(err)
+	}
+	if ni.GPUs[1].CoreAvailable != 50 || ni.GPUs[1].MemoryAvailable != 40 {
+		t.Fatalf("expected device 2 released, got %+v", ni.GPUs[1])
+	}
+	if err := ni.Add(bound, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ni.GPUs[1].CoreAvailable != 20 || ni.GPUs[0].CoreAvailable != 100 {
+		t.Fatalf("expected device 2 used again, got %s", ni.GPUs)
+	}
+}
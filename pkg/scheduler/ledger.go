@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DeviceLedger records the mode holding each GPU device of the nodes. Schedulers of different modes
+// keep their own allocation of a node, they go through the ledger so that a device is never handed
+// out by two of them. A mode is named by the core resource of its scheduler.
+type DeviceLedger struct {
+	lock  sync.Mutex
+	nodes map[string]map[int]*deviceHolder
+}
+
+// deviceHolder is the mode holding a device and the pods of the mode on it.
+type deviceHolder struct {
+	mode v1.ResourceName
+	pods map[types.UID]struct{}
+}
+
+func NewDeviceLedger() *DeviceLedger {
+	return &DeviceLedger{nodes: make(map[string]map[int]*deviceHolder)}
+}
+
+// Claim records the devices of the node as held by the pod of the mode, nothing is claimed if any of
+// them is held by another mode.
+func (l *DeviceLedger) Claim(node string, mode v1.ResourceName, uid types.UID, devices []int) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	holders := l.nodes[node]
+	for _, device := range devices {
+		if holder, ok := holders[device]; ok && holder.mode != mode {
+			return fmt.Errorf("gpu %d of node %s is held by %s", device, node, holder.mode)
+		}
+	}
+	if len(devices) == 0 {
+		return nil
+	}
+	if holders == nil {
+		holders = make(map[int]*deviceHolder)
+		l.nodes[node] = holders
+	}
+	for _, device := range devices {
+		holder, ok := holders[device]
+		if !ok {
+			holder = &deviceHolder{mode: mode, pods: make(map[types.UID]struct{})}
+			holders[device] = holder
+		}
+		holder.pods[uid] = struct{}{}
+	}
+	return nil
+}
+
+// Release drops the claims of the pod on the node, devices without pods are free for any mode.
+func (l *DeviceLedger) Release(node string, uid types.UID) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	holders := l.nodes[node]
+	for device, holder := range holders {
+		delete(holder.pods, uid)
+		if len(holder.pods) == 0 {
+			delete(holders, device)
+		}
+	}
+	if len(holders) == 0 {
+		delete(l.nodes, node)
+	}
+}
+
+// HeldByOthers returns the sorted devices of the node held by modes other than the given one.
+func (l *DeviceLedger) HeldByOthers(node string, mode v1.ResourceName) []int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	devices := make([]int, 0)
+	for device, holder := range l.nodes[node] {
+		if holder.mode != mode {
+			devices = append(devices, device)
+		}
+	}
+	sort.Ints(devices)
+	return devices
+}
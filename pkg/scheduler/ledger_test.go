@@ -0,0 +1,137 @@
+package scheduler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestDeviceLedger(t *testing.T) {
+	ledger := NewDeviceLedger()
+	if err := ledger.Claim("node", v1alpha1.ResourceGPUCore, "a", []int{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.Claim("node", v1alpha1.ResourceGPUCore, "b", []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.Claim("node", v1alpha1.ResourceQGPUCore, "c", []int{1, 2}); err == nil || !strings.Contains(err.Error(), "gpu 1 of node node is held by") {
+		t.Fatalf("expected gpu 1 held by gpushare, got %v", err)
+	}
+	if held := ledger.HeldByOthers("node", v1alpha1.ResourceQGPUCore); !reflect.DeepEqual(held, []int{0, 1}) {
+		t.Fatalf("expected gpus [0 1] held by others, got %v", held)
+	}
+	if err := ledger.Claim("other", v1alpha1.ResourceQGPUCore, "c", []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	ledger.Release("node", "a")
+	if held := ledger.HeldByOthers("node", v1alpha1.ResourceQGPUCore); !reflect.DeepEqual(held, []int{1}) {
+		t.Fatalf("expected gpu [1] held by others, got %v", held)
+	}
+	ledger.Release("node", "b")
+	if err := ledger.Claim("node", v1alpha1.ResourceQGPUCore, "c", []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCrossModeAllocation(t *testing.T) {
+	node := newMixedModeNode()
+	ledger := NewDeviceLedger()
+	share, err := newNodeAllocator(nil, node, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{}, ledger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qgpu, err := newNodeAllocator(nil, node, v1alpha1.ResourceQGPUCore, v1alpha1.ResourceQGPUMemory, &Binpack{}, ledger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pending assumption of the qgpu pod holds gpu 0
+	qgpuPod := newModePod("qgpu", v1alpha1.ResourceQGPUCore, v1alpha1.ResourceQGPUMemory, "30", "4")
+	if ids, err := qgpu.Assume(&qgpuPod); err != nil || !reflect.DeepEqual(ids, GPUIDs{{0}}) {
+		t.Fatalf("expected qgpu pod assumed on gpu 0, got %v, %v", ids, err)
+	}
+	sharePod := newModePod("share", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+	if _, err := share.Assume(&sharePod); err != nil {
+		t.Fatal(err)
+	}
+	shareIDs, err := share.Allocate(&sharePod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(shareIDs, GPUIDs{{1}}) {
+		t.Fatalf("expected gpushare pod on gpu 1, got %v", shareIDs)
+	}
+	qgpuIDs, err := qgpu.Allocate(&qgpuPod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(qgpuIDs, GPUIDs{{0}}) {
+		t.Fatalf("expected qgpu pod on gpu 0, got %v", qgpuIDs)
+	}
+
+	whole := newModePod("whole", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "100", "0")
+	if _, err := share.Assume(&whole); err == nil {
+		t.Fatal("expected no gpushare option with a gpu held by qgpu")
+	}
+	qgpuWhole := newModePod("qgpu-whole", v1alpha1.ResourceQGPUCore, v1alpha1.ResourceQGPUMemory, "100", "0")
+	if _, err := qgpu.Assume(&qgpuWhole); err == nil {
+		t.Fatal("expected no qgpu option with a gpu held by gpushare")
+	}
+
+	// released gpus can be used by the other mode
+	if err := share.Forget(GetUpdatedPodAnnotationSpec(&sharePod, shareIDs)); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := qgpu.Assume(&qgpuWhole); err != nil || !reflect.DeepEqual(ids, GPUIDs{{1}}) {
+		t.Fatalf("expected qgpu pod assumed on the released gpu 1, got %v, %v", ids, err)
+	}
+	qgpu.Unassume(&qgpuWhole)
+	if held := ledger.HeldByOthers(node.Name, v1alpha1.ResourceGPUCore); !reflect.DeepEqual(held, []int{0}) {
+		t.Fatalf("expected gpu [0] held by qgpu, got %v", held)
+	}
+}
+
+func TestGetResourceScheduler(t *testing.T) {
+	gpushare, qgpu := &GPUUnitScheduler{}, &GPUUnitScheduler{}
+	registered := map[v1.ResourceName]ResourceScheduler{
+		v1alpha1.ResourcePGPU:       gpushare,
+		v1alpha1.ResourceGPUCore:    gpushare,
+		v1alpha1.ResourceGPUMemory:  gpushare,
+		v1alpha1.ResourceQGPUCore:   qgpu,
+		v1alpha1.ResourceQGPUMemory: qgpu,
+	}
+
+	pod := newModePod("qgpu", v1alpha1.ResourceQGPUCore, v1alpha1.ResourceQGPUMemory, "30", "4")
+	for i := 0; i < 10; i++ {
+		if d, err := GetResourceScheduler(&pod, registered); err != nil || d != qgpu {
+			t.Fatalf("expected the qgpu scheduler, got %v", err)
+		}
+	}
+
+	// pgpu and gpushare are the same mode
+	pod = newPGPUPod("pgpu", "1")
+	pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: "share", Resources: v1.ResourceRequirements{
+		Requests: v1.ResourceList{v1alpha1.ResourceGPUCore: resource.MustParse("20")},
+	}})
+	if d, err := GetResourceScheduler(&pod, registered); err != nil || d != gpushare {
+		t.Fatalf("expected the gpushare scheduler, got %v", err)
+	}
+
+	pod.Spec.InitContainers = []v1.Container{{Name: "init", Resources: v1.ResourceRequirements{
+		Requests: v1.ResourceList{v1alpha1.ResourceQGPUMemory: resource.MustParse("4")},
+	}}}
+	if _, err := GetResourceScheduler(&pod, registered); err == nil || !strings.Contains(err.Error(), "requests resources of different modes") {
+		t.Fatalf("expected pod of two modes rejected, got %v", err)
+	}
+
+	pod = generatePods("none", 1)[0]
+	pod.Spec.Containers[0].Resources.Requests = v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}
+	if _, err := GetResourceScheduler(&pod, registered); err == nil {
+		t.Fatal("expected no scheduler for a pod without gpu resources")
+	}
+}
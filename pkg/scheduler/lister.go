@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"fmt"
+
+	schetypes "elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clientgocache "k8s.io/client-go/tools/cache"
+)
+
+// ClusterLister serves the nodes and the pods bound to them from the shared informer caches, so that
+// node allocators are built without calling the API server.
+type ClusterLister struct {
+	nodes  corelisters.NodeLister
+	pods   clientgocache.Indexer
+	synced []clientgocache.InformerSynced
+}
+
+// NewClusterLister indexes the pods of the informer factory by their node, it must be called before
+// the factory is started.
+func NewClusterLister(factory informers.SharedInformerFactory) (*ClusterLister, error) {
+	podInformer := factory.Core().V1().Pods().Informer()
+	if err := podInformer.AddIndexers(clientgocache.Indexers{schetypes.NodeNameField: podNodeName}); err != nil {
+		return nil, err
+	}
+	nodeInformer := factory.Core().V1().Nodes()
+	return &ClusterLister{
+		nodes:  nodeInformer.Lister(),
+		pods:   podInformer.GetIndexer(),
+		synced: []clientgocache.InformerSynced{podInformer.HasSynced, nodeInformer.Informer().HasSynced},
+	}, nil
+}
+
+// podNodeName indexes pods by the node they are bound to.
+func podNodeName(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return nil, fmt.Errorf("cannot index %T by node", obj)
+	}
+	if pod.Spec.NodeName == "" {
+		return nil, nil
+	}
+	return []string{pod.Spec.NodeName}, nil
+}
+
+// WaitForCacheSync waits for the nodes and pods to be listed, it returns false if stopped before.
+func (l *ClusterLister) WaitForCacheSync(stopCh <-chan struct{}) bool {
+	return clientgocache.WaitForCacheSync(stopCh, l.synced...)
+}
+
+func (l *ClusterLister) GetNode(name string) (*v1.Node, error) {
+	return l.nodes.Get(name)
+}
+
+// AssumedPods returns the pods bound to the node with GPUs assumed by the scheduler.
+func (l *ClusterLister) AssumedPods(node string) ([]v1.Pod, error) {
+	objs, err := l.pods.ByIndex(schetypes.NodeNameField, node)
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]v1.Pod, 0, len(objs))
+	for _, obj := range objs {
+		if pod, ok := obj.(*v1.Pod); ok && pod.Labels[schetypes.EGPUAssumed] == "true" {
+			pods = append(pods, *pod)
+		}
+	}
+	return pods, nil
+}
+
+// AssumedNodes returns the nodes with pods bound to them with GPUs assumed by the scheduler.
+func (l *ClusterLister) AssumedNodes() []string {
+	nodes := make([]string, 0)
+	for _, node := range l.pods.ListIndexFuncValues(schetypes.NodeNameField) {
+		if pods, err := l.AssumedPods(node); err == nil && len(pods) > 0 {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,71 @@
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clientgocache "k8s.io/client-go/tools/cache"
+)
+
+// newTestClusterLister returns a lister of the nodes and pods, as the informers would list them.
+func newTestClusterLister(t *testing.T, nodes []*v1.Node, pods []*v1.Pod) *ClusterLister {
+	nodeIndexer := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+	for _, node := range nodes {
+		if err := nodeIndexer.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	podIndexer := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{utils.NodeNameField: podNodeName})
+	for _, pod := range pods {
+		if err := podIndexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &ClusterLister{nodes: corelisters.NewNodeLister(nodeIndexer), pods: podIndexer}
+}
+
+func TestClusterLister(t *testing.T) {
+	node := newTopologyNode(2, "")
+	share := newModePod("share", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+	bound := GetUpdatedPodAnnotationSpec(&share, GPUIDs{{1}})
+	bound.Spec.NodeName = node.Name
+	// bound by another scheduler, without gpus assumed
+	other := newModePod("other", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+	other.Spec.NodeName = node.Name
+	pending := newModePod("pending", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+	elsewhere := bound.DeepCopy()
+	elsewhere.Name, elsewhere.UID, elsewhere.Spec.NodeName = "elsewhere", "elsewhere", "other-node"
+	lister := newTestClusterLister(t, []*v1.Node{node}, []*v1.Pod{bound, &other, &pending, elsewhere})
+
+	pods, err := lister.AssumedPods(node.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1 || pods[0].UID != bound.UID {
+		t.Fatalf("expected the bound pod, got %v", pods)
+	}
+	nodes := lister.AssumedNodes()
+	if len(nodes) != 2 || !containsString(nodes, node.Name) || !containsString(nodes, "other-node") {
+		t.Fatalf("expected nodes %s and other-node, got %v", node.Name, nodes)
+	}
+
+	config := ElasticSchedulerConfig{Lister: lister, Rater: &Binpack{}}
+	d := &GPUUnitScheduler{BaseScheduler: newBaseScheduler(config, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)}
+	ni, err := d.getNodeInfo(node.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ni.GPUs[1].CoreAvailable != 40 || ni.GPUs[0].CoreAvailable != 100 {
+		t.Fatalf("expected the bound pod on gpu 1, got %s", ni.GPUs)
+	}
+	if !reflect.DeepEqual(ni.Pods(), []v1.Pod{*bound}) {
+		t.Fatalf("expected the bound pod added, got %v", ni.Pods())
+	}
+	if _, err := d.getNodeInfo("not-found"); err == nil {
+		t.Fatal("expected no node not listed")
+	}
+}
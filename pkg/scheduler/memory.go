@@ -0,0 +1,44 @@
+package scheduler
+
+import (
+	"fmt"
+	"math"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var maxGPUMemoryQuantity = *resource.NewQuantity(math.MaxInt64, resource.DecimalSI)
+
+// GPUMemoryFromQuantity converts a gpu memory quantity to the canonical unit, utils.GPUMemoryUnit.
+// Quantities with a binary suffix, such as 16Gi or 16000Mi, are amounts of bytes and must be whole
+// multiples of the unit; other whole numbers, such as 16384, are counts of the unit. The API server
+// keeps quantities in their canonical form, where 16000 reads as 16k, so decimal suffixes count the
+// unit too, but whole millions and more, such as 1M or 16G, are taken for bytes and rejected: no GPU
+// has a TiB of memory.
+func GPUMemoryFromQuantity(q resource.Quantity) (int, error) {
+	if q.Sign() < 0 {
+		return 0, fmt.Errorf("gpu memory %s is negative", q.String())
+	}
+	// quantities beyond int64 are capped to the max when parsed
+	if q.Cmp(maxGPUMemoryQuantity) >= 0 {
+		return 0, fmt.Errorf("gpu memory %s overflows", q.String())
+	}
+	value := q.Value()
+	if q.Cmp(*resource.NewQuantity(value, q.Format)) != 0 {
+		return 0, fmt.Errorf("gpu memory %s is not an integer", q.String())
+	}
+	if _, exponent := q.AsCanonicalBytes(nil); q.Format != resource.BinarySI && exponent >= 6 {
+		return 0, fmt.Errorf("gpu memory %s is read as %d Mi, use Mi, Gi or a plain count of Mi", q.String(), value)
+	}
+	if q.Format == resource.BinarySI {
+		if value%utils.GPUMemoryUnit != 0 {
+			return 0, fmt.Errorf("gpu memory %s is not a multiple of %s", q.String(), resource.NewQuantity(utils.GPUMemoryUnit, resource.BinarySI))
+		}
+		value /= utils.GPUMemoryUnit
+	}
+	if int64(int(value)) != value {
+		return 0, fmt.Errorf("gpu memory %s overflows", q.String())
+	}
+	return int(value), nil
+}
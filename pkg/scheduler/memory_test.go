@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestGPUMemoryFromQuantity(t *testing.T) {
+	tests := []struct {
+		quantity string
+		want     int
+		wantErr  string
+	}{
+		{quantity: "16", want: 16},
+		{quantity: "16Gi", want: 16384},
+		{quantity: "16000Mi", want: 16000},
+		{quantity: "1.5Gi", want: 1536},
+		{quantity: "0", want: 0},
+		{quantity: "16000", want: 16000},
+		{quantity: "32k", want: 32000},
+		{quantity: "16e3", want: 16000},
+		{quantity: "1000Ki", wantErr: "not a multiple of 1Mi"},
+		{quantity: "16G", wantErr: "use Mi, Gi or a plain count"},
+		{quantity: "1M", wantErr: "use Mi, Gi or a plain count"},
+		{quantity: "-1", wantErr: "negative"},
+		{quantity: "0.5", wantErr: "not an integer"},
+		{quantity: "1.5", wantErr: "not an integer"},
+		{quantity: "100Ei", wantErr: "overflows"},
+	}
+	for _, tt := range tests {
+		got, err := GPUMemoryFromQuantity(resource.MustParse(tt.quantity))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("GPUMemoryFromQuantity(%s) = %d, %v, want error %q", tt.quantity, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("GPUMemoryFromQuantity(%s) = %d, %v, want %d", tt.quantity, got, err, tt.want)
+		}
+	}
+}
+
+func TestNodeAndPodMemoryUnits(t *testing.T) {
+	ni, err := NewNodeAllocator(nil, newInventoryNode("200", "32Gi", ""), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ni.GPUs[0].MemoryTotal != 16384 || ni.GPUs[1].MemoryTotal != 16384 {
+		t.Fatalf("expected 16384 memory on each gpu, got %s", ni.GPUs)
+	}
+
+	// the same amount written in bytes and in units
+	for _, memory := range []string{"4Gi", "4096Mi", "4096"} {
+		pod := generatePods("memory", 1)[0]
+		pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUMemory] = resource.MustParse(memory)
+		request, err := NewGPURequest(&pod, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if request[0].Memory != 4096 {
+			t.Fatalf("expected memory 4096 for %s, got %d", memory, request[0].Memory)
+		}
+	}
+
+	pod := generatePods("memory", 1)[0]
+	pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUMemory] = resource.MustParse("100Ki")
+	if _, err := ni.Assume(&pod); err == nil || !strings.Contains(err.Error(), "not a multiple") {
+		t.Fatalf("expected unit error, got %v", err)
+	}
+
+	if _, err := NewNodeAllocator(nil, newInventoryNode("100", "1000Ki", ""), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{}); err == nil {
+		t.Fatal("expected error for node memory which isn't a multiple of the unit")
+	}
+}
@@ -0,0 +1,141 @@
+package scheduler
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/metrics"
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Reasons the operations of the scheduler fail for, see metrics.Failures.
+const (
+	reasonNodeUnavailable = "node_unavailable"
+	reasonInvalidRequest  = "invalid_request"
+	reasonConstraint      = "constraint"
+	reasonInsufficientGPU = "insufficient_gpu"
+	reasonDeviceConflict  = "device_conflict"
+	reasonAlreadyBound    = "already_bound"
+	reasonBindInProgress  = "bind_in_progress"
+	reasonPatchFailed     = "patch_failed"
+	reasonBindingFailed   = "binding_failed"
+	reasonUnknown         = "unknown"
+)
+
+// failure is an error with the reason it's counted for.
+type failure struct {
+	reason string
+	err    error
+}
+
+func (f *failure) Error() string {
+	return f.err.Error()
+}
+
+func (f *failure) Unwrap() error {
+	return f.err
+}
+
+func withReason(reason string, err error) error {
+	return &failure{reason: reason, err: err}
+}
+
+// failureReason returns the reason of the error, reasonUnknown if it has none.
+func failureReason(err error) string {
+	var f *failure
+	if errors.As(err, &f) {
+		return f.reason
+	}
+	return reasonUnknown
+}
+
+var (
+	nodeLabels = []string{"resource", "node"}
+	gpuLabels  = []string{"resource", "node", "gpu"}
+
+	nodeCoreAllocatedDesc   = newDesc("node_core_allocated", "GPU core allocated on the node, in percent of a GPU.", nodeLabels)
+	nodeCoreFreeDesc        = newDesc("node_core_free", "GPU core free on the node, in percent of a GPU.", nodeLabels)
+	nodeMemoryAllocatedDesc = newDesc("node_memory_allocated_bytes", "GPU memory allocated on the node in bytes.", nodeLabels)
+	nodeMemoryFreeDesc      = newDesc("node_memory_free_bytes", "GPU memory free on the node in bytes.", nodeLabels)
+	nodeAssumedDesc         = newDesc("node_assumed_pods", "Pods assumed on the node and not bound yet.", nodeLabels)
+	nodeOvercommittedDesc   = newDesc("node_overcommitted_pods", "Pods bound to the node whose allocations don't fit its GPUs.", nodeLabels)
+	gpuCoreAllocatedDesc    = newDesc("gpu_core_allocated", "Core allocated on the GPU, in percent.", gpuLabels)
+	gpuCoreFreeDesc         = newDesc("gpu_core_free", "Core free on the GPU, in percent.", gpuLabels)
+	gpuMemoryAllocatedDesc  = newDesc("gpu_memory_allocated_bytes", "Memory allocated on the GPU in bytes.", gpuLabels)
+	gpuMemoryFreeDesc       = newDesc("gpu_memory_free_bytes", "Memory free on the GPU in bytes.", gpuLabels)
+	gpuTenantsDesc          = newDesc("gpu_tenants", "Pods bound or assumed on the GPU.", gpuLabels)
+)
+
+func newDesc(name, help string, labels []string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "", name), help, labels, nil)
+}
+
+// nodeCollector reports the state of the nodes known by the schedulers when metrics are scraped.
+type nodeCollector struct {
+	// schedulers holds each scheduler once, by the first of the resources it's registered for.
+	schedulers map[string]ResourceScheduler
+}
+
+// RegisterNodeMetrics reports the usage of the GPUs of the nodes known by the schedulers.
+func RegisterNodeMetrics(sches map[v1.ResourceName]ResourceScheduler) error {
+	names := make([]string, 0, len(sches))
+	for name := range sches {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	c := &nodeCollector{schedulers: make(map[string]ResourceScheduler)}
+	seen := map[ResourceScheduler]struct{}{}
+	for _, name := range names {
+		d := sches[v1.ResourceName(name)]
+		if _, ok := seen[d]; !ok {
+			seen[d] = struct{}{}
+			c.schedulers[name] = d
+		}
+	}
+	return metrics.Register(c)
+}
+
+func (c *nodeCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		nodeCoreAllocatedDesc, nodeCoreFreeDesc, nodeMemoryAllocatedDesc, nodeMemoryFreeDesc, nodeAssumedDesc, nodeOvercommittedDesc,
+		gpuCoreAllocatedDesc, gpuCoreFreeDesc, gpuMemoryAllocatedDesc, gpuMemoryFreeDesc, gpuTenantsDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *nodeCollector) Collect(ch chan<- prometheus.Metric) {
+	gauge := func(desc *prometheus.Desc, value int, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value), labels...)
+	}
+	// memory is accounted in utils.GPUMemoryUnit and exported in bytes
+	memoryGauge := func(desc *prometheus.Desc, value int, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value)*utils.GPUMemoryUnit, labels...)
+	}
+	for resource, d := range c.schedulers {
+		for node, status := range d.NodeStatuses() {
+			var coreTotal, coreFree, memoryTotal, memoryFree int
+			for _, gpu := range status.GPUs {
+				index := strconv.Itoa(gpu.Index)
+				gauge(gpuCoreAllocatedDesc, gpu.CoreTotal-gpu.CoreAvailable, resource, node, index)
+				gauge(gpuCoreFreeDesc, gpu.CoreAvailable, resource, node, index)
+				memoryGauge(gpuMemoryAllocatedDesc, gpu.MemoryTotal-gpu.MemoryAvailable, resource, node, index)
+				memoryGauge(gpuMemoryFreeDesc, gpu.MemoryAvailable, resource, node, index)
+				gauge(gpuTenantsDesc, gpu.Tenants, resource, node, index)
+				coreTotal += gpu.CoreTotal
+				coreFree += gpu.CoreAvailable
+				memoryTotal += gpu.MemoryTotal
+				memoryFree += gpu.MemoryAvailable
+			}
+			gauge(nodeCoreAllocatedDesc, coreTotal-coreFree, resource, node)
+			gauge(nodeCoreFreeDesc, coreFree, resource, node)
+			memoryGauge(nodeMemoryAllocatedDesc, memoryTotal-memoryFree, resource, node)
+			memoryGauge(nodeMemoryFreeDesc, memoryFree, resource, node)
+			gauge(nodeAssumedDesc, status.Assumed, resource, node)
+			gauge(nodeOvercommittedDesc, len(status.Overcommitted), resource, node)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	v1 "k8s.io/api/core/v1"
+)
+
+// collect returns the values of the metrics of the collector, by name and labels sorted by name.
+func collect(t *testing.T, c prometheus.Collector) map[string]float64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	values := make(map[string]float64)
+	for m := range ch {
+		var out dto.Metric
+		if err := m.Write(&out); err != nil {
+			t.Fatal(err)
+		}
+		name := m.Desc().String()
+		name = name[strings.Index(name, `"`)+1:]
+		name = name[:strings.Index(name, `"`)]
+		for _, label := range out.GetLabel() {
+			name += "," + label.GetName() + "=" + label.GetValue()
+		}
+		values[name] = out.GetGauge().GetValue()
+	}
+	return values
+}
+
+func TestNodeCollector(t *testing.T) {
+	node := newTopologyNode(2, "")
+	share := newModePod("share", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+	bound := GetUpdatedPodAnnotationSpec(&share, GPUIDs{{0}})
+	bound.Spec.NodeName = node.Name
+	lister := newTestClusterLister(t, []*v1.Node{node}, []*v1.Pod{bound})
+	config := ElasticSchedulerConfig{Lister: lister, Rater: &Binpack{}}
+	d := &GPUUnitScheduler{BaseScheduler: newBaseScheduler(config, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)}
+	pending := newModePod("pending", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "20", "4")
+	if _, _, err := d.Assume([]string{node.Name}, &pending); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &nodeCollector{schedulers: map[string]ResourceScheduler{string(v1alpha1.ResourceGPUCore): d}}
+	got := collect(t, c)
+	prefix := "elastic_gpu_scheduler_"
+	labels := ",node=" + node.Name + ",resource=" + string(v1alpha1.ResourceGPUCore)
+	gpu := func(name, index string) string {
+		return name + ",gpu=" + index + labels
+	}
+	for name, want := range map[string]float64{
+		"node_core_allocated" + labels:         80,
+		"node_core_free" + labels:              120,
+		"node_memory_allocated_bytes" + labels: 12 * utils.GPUMemoryUnit,
+		"node_memory_free_bytes" + labels:      20 * utils.GPUMemoryUnit,
+		"node_assumed_pods" + labels:           1,
+		"node_overcommitted_pods" + labels:     0,
+		gpu("gpu_core_allocated", "0"):         80,
+		gpu("gpu_core_free", "1"):              100,
+		gpu("gpu_memory_free_bytes", "1"):      16 * utils.GPUMemoryUnit,
+		gpu("gpu_tenants", "0"):                2,
+	} {
+		if got[prefix+name] != want {
+			t.Errorf("expected %s to be %v, got %v", name, want, got[prefix+name])
+		}
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	node := newTopologyNode(1, "")
+	lister := newTestClusterLister(t, []*v1.Node{node}, nil)
+	config := ElasticSchedulerConfig{Lister: lister, Rater: &Binpack{}}
+	d := &GPUUnitScheduler{BaseScheduler: newBaseScheduler(config, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)}
+	ni, err := d.lockNode(node.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ni.lock.Unlock()
+
+	large := newModePod("large", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "200", "32")
+	if _, err := ni.Assume(&large); failureReason(err) != reasonInsufficientGPU {
+		t.Fatalf("expected %s, got %v", reasonInsufficientGPU, err)
+	}
+	invalid := newModePod("invalid", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "-1", "4")
+	if _, err := ni.Assume(&invalid); failureReason(err) != reasonInvalidRequest {
+		t.Fatalf("expected %s, got %v", reasonInvalidRequest, err)
+	}
+	// the reason is kept when allocating wraps the error
+	if _, err := ni.Allocate(&large); failureReason(err) != reasonInsufficientGPU {
+		t.Fatalf("expected %s, got %v", reasonInsufficientGPU, err)
+	}
+}
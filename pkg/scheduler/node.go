@@ -6,51 +6,68 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2"
+	"sort"
+	"sync"
+	"time"
 )
 
 type GPUIDs [][]int
 
 type NodeAllocator struct {
-	Rater     Rater
-	GPUs      GPUs
-	podsMap   map[types.UID]*v1.Pod
-	Node      *v1.Node
-	allocated map[string]*GPUOption
-	CoreName  v1.ResourceName
-	MemName   v1.ResourceName
+	// lock guards the allocator, it's taken by the scheduler, see BaseScheduler.
+	lock sync.Mutex
+	// closed is set once the allocator is replaced or dropped.
+	closed  bool
+	Rater   Rater
+	GPUs    GPUs
+	podsMap map[types.UID]*v1.Pod
+	Node    *v1.Node
+	// assumed holds the options of the pods assumed on the node and not bound yet, they are taken on
+	// GPUs until the pods are bound or forgotten.
+	assumed map[types.UID]*assumption
+	// overcommitted holds the pods bound to the node whose allocations don't fit its GPUs, they are
+	// added once other pods give back enough.
+	overcommitted map[types.UID]*v1.Pod
+	CoreName      v1.ResourceName
+	MemName       v1.ResourceName
+	// Ledger arbitrates the devices of the node between modes, nil if the allocator is the only one
+	// of the node.
+	Ledger *DeviceLedger
 }
 
 func NewNodeAllocator(pods []v1.Pod, node *v1.Node, core v1.ResourceName, mem v1.ResourceName, rater Rater) (*NodeAllocator, error) {
-	coreAvail := node.Status.Allocatable[core]
-	// TODO: GB only
-	memAvail := node.Status.Allocatable[mem]
-	gpuCount := int(coreAvail.Value() / utils.GPUCoreEachCard)
-	if gpuCount == 0 {
-		return nil, fmt.Errorf("no gpu available on node %s", node.Name)
-	}
+	return newNodeAllocator(pods, node, core, mem, rater, nil)
+}
 
-	gpus := make(GPUs, 0)
-	for i := 0; i < gpuCount; i++ {
-		gpus = append(gpus, &GPU{
-			CoreAvailable:   utils.GPUCoreEachCard,
-			CoreTotal:       utils.GPUCoreEachCard,
-			MemoryAvailable: int(memAvail.Value()) / gpuCount,
-			MemoryTotal:     int(memAvail.Value()) / gpuCount,
-		})
+func newNodeAllocator(pods []v1.Pod, node *v1.Node, core v1.ResourceName, mem v1.ResourceName, rater Rater, ledger *DeviceLedger) (*NodeAllocator, error) {
+	gpus, err := buildNodeGPUs(node, core, mem)
+	if err != nil {
+		return nil, err
+	}
+	if topology, err := GetNodeGPUTopology(node); err != nil {
+		klog.Errorf("Ignore gpu topology of node %s: %v", node.Name, err)
+	} else if topology != nil {
+		if err := topology.Apply(gpus); err != nil {
+			klog.Errorf("Ignore gpu topology of node %s: %v", node.Name, err)
+		}
 	}
 
 	na := &NodeAllocator{
-		GPUs:      gpus,
-		Rater:     rater,
-		allocated: make(map[string]*GPUOption),
-		podsMap:   make(map[types.UID]*v1.Pod),
-		Node:      node,
-		CoreName:  core,
-		MemName:   mem,
+		GPUs:          gpus,
+		Rater:         rater,
+		assumed:       make(map[types.UID]*assumption),
+		overcommitted: make(map[types.UID]*v1.Pod),
+		podsMap:       make(map[types.UID]*v1.Pod),
+		Node:          node,
+		CoreName:      core,
+		MemName:       mem,
+		Ledger:        ledger,
 	}
 
 	for i, _ := range pods {
-		na.Add(&pods[i], nil)
+		if err := na.Add(&pods[i], nil); err != nil {
+			klog.Errorf("Failed to add pod %s/%s to node %s: %v", pods[i].Namespace, pods[i].Name, node.Name, err)
+		}
 	}
 
 	klog.V(5).Infof("Node %s gpu allocation: %+v", node.Name, na.GPUs)
@@ -58,49 +75,213 @@ func NewNodeAllocator(pods []v1.Pod, node *v1.Node, core v1.ResourceName, mem v1
 	return na, nil
 }
 
+// assumption is the option of an assumed pod, with the key of the request it was chosen for.
+type assumption struct {
+	pod       string
+	key       string
+	option    *GPUOption
+	assumedAt time.Time
+}
+
+// optionKey returns the key of the pod's request, pods with the same request but different policies
+// are rated differently and get different options.
+func optionKey(pod *v1.Pod, req GPURequest) string {
+	if policy := pod.Annotations[utils.AnnotationEGPUPolicy]; policy != "" {
+		return req.Hash() + "/" + policy
+	}
+	return req.Hash()
+}
+
+// Assume chooses an option of the pod and takes it on GPUs until the pod is bound or forgotten, so
+// that pods assumed after it can't be promised the same resources. The option is kept as long as the
+// pod's request doesn't change.
 func (ni *NodeAllocator) Assume(pod *v1.Pod) (GPUIDs, error) {
-	req := NewGPURequest(pod, ni.CoreName, ni.MemName)
-	key := req.Hash()
-	if option, ok := ni.allocated[key]; ok {
-		return option.Allocated, nil
+	req, err := NewGPURequest(pod, ni.CoreName, ni.MemName)
+	if err != nil {
+		return nil, withReason(reasonInvalidRequest, err)
 	}
-	option, err := ni.GPUs.Trade(ni.Rater, req)
+	key := optionKey(pod, req)
+	if a, ok := ni.assumed[pod.UID]; ok {
+		if a.key == key {
+			a.assumedAt = time.Now()
+			return a.option.Allocated, nil
+		}
+		ni.Unassume(pod)
+	}
+	rater, err := GetPodRater(pod, ni.Rater)
 	if err != nil {
-		return nil, err
+		return nil, withReason(reasonInvalidRequest, err)
+	}
+	constraint, err := NewGPUConstraint(pod)
+	if err != nil {
+		return nil, withReason(reasonInvalidRequest, err)
+	}
+	if constraint != nil {
+		if err := constraint.Check(ni.GPUs); err != nil {
+			return nil, withReason(reasonConstraint, err)
+		}
+	}
+	option, err := ni.tradableGPUs().Trade(rater, req)
+	if err != nil {
+		return nil, withReason(reasonInsufficientGPU, err)
+	}
+	if err := ni.claim(pod, option); err != nil {
+		return nil, withReason(reasonDeviceConflict, err)
+	}
+	if err := ni.GPUs.Transact(option); err != nil {
+		ni.release(pod.UID)
+		return nil, withReason(reasonInsufficientGPU, err)
+	}
+	ni.assumed[pod.UID] = &assumption{
+		pod:       pod.Namespace + "/" + pod.Name,
+		key:       key,
+		option:    option,
+		assumedAt: time.Now(),
 	}
-	ni.allocated[key] = option
 	return option.Allocated, nil
 }
 
-func (ni *NodeAllocator) Score(pod *v1.Pod) int {
-	req := NewGPURequest(pod, ni.CoreName, ni.MemName)
-	key := req.Hash()
-	option, ok := ni.allocated[key]
+// Unassume gives back the option of the pod if it's assumed on the node.
+func (ni *NodeAllocator) Unassume(pod *v1.Pod) {
+	ni.unassume(pod.UID)
+}
+
+func (ni *NodeAllocator) unassume(uid types.UID) {
+	a, ok := ni.assumed[uid]
 	if !ok {
+		return
+	}
+	klog.V(5).Infof("Unassume pod %s option %+v on node %s", a.pod, a.option, ni.Node.Name)
+	ni.GPUs.Cancel(a.option)
+	ni.release(uid)
+	delete(ni.assumed, uid)
+}
+
+// Expire gives back the options of the pods assumed before the given time and not assumed again
+// since, and returns how many were given back.
+func (ni *NodeAllocator) Expire(before time.Time) int {
+	expired := 0
+	for uid, a := range ni.assumed {
+		if a.assumedAt.Before(before) {
+			klog.V(3).Infof("Assumption of pod %s on node %s expired", a.pod, ni.Node.Name)
+			ni.unassume(uid)
+			expired++
+		}
+	}
+	return expired
+}
+
+// Assumed returns the number of pods assumed on the node and not bound yet.
+func (ni *NodeAllocator) Assumed() int {
+	return len(ni.assumed)
+}
+
+// Overcommitted returns the sorted names of the pods bound to the node whose allocations don't fit
+// its GPUs.
+func (ni *NodeAllocator) Overcommitted() []string {
+	names := make([]string, 0, len(ni.overcommitted))
+	for _, pod := range ni.overcommitted {
+		names = append(names, pod.Namespace+"/"+pod.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Status returns the status of the node.
+func (ni *NodeAllocator) Status() NodeStatus {
+	gpus := make([]GPUStatus, len(ni.GPUs))
+	for i, gpu := range ni.GPUs {
+		gpus[i] = GPUStatus{
+			Index:           gpu.Index,
+			CoreTotal:       gpu.CoreTotal,
+			CoreAvailable:   gpu.CoreAvailable,
+			MemoryTotal:     gpu.MemoryTotal,
+			MemoryAvailable: gpu.MemoryAvailable,
+			Tenants:         gpu.Tenants,
+		}
+	}
+	return NodeStatus{Assumed: ni.Assumed(), Overcommitted: ni.Overcommitted(), GPUs: gpus}
+}
+
+// Pods returns the pods bound to the node, including the overcommitted ones, oldest first.
+func (ni *NodeAllocator) Pods() []v1.Pod {
+	pods := make([]v1.Pod, 0, len(ni.podsMap)+len(ni.overcommitted))
+	for _, pod := range ni.podsMap {
+		pods = append(pods, *pod)
+	}
+	for _, pod := range ni.overcommitted {
+		pods = append(pods, *pod)
+	}
+	sortPods(pods)
+	return pods
+}
+
+// sortPods sorts the pods oldest first, pods are added in order, so that older pods come first when
+// not all of them fit.
+func sortPods(pods []v1.Pod) {
+	sort.Slice(pods, func(i, j int) bool {
+		if !pods[i].CreationTimestamp.Equal(&pods[j].CreationTimestamp) {
+			return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+		}
+		if key, other := pods[i].Namespace+"/"+pods[i].Name, pods[j].Namespace+"/"+pods[j].Name; key != other {
+			return key < other
+		}
+		return pods[i].UID < pods[j].UID
+	})
+}
+
+// Close gives back what the allocator holds in the ledger, when it's dropped or replaced. Closing it
+// again does nothing.
+func (ni *NodeAllocator) Close() {
+	if ni.closed {
+		return
+	}
+	ni.closed = true
+	for uid := range ni.assumed {
+		ni.unassume(uid)
+	}
+	for uid := range ni.podsMap {
+		ni.release(uid)
+	}
+	for uid := range ni.overcommitted {
+		ni.release(uid)
+	}
+}
+
+func (ni *NodeAllocator) Score(pod *v1.Pod) int {
+	if _, ok := ni.assumed[pod.UID]; !ok {
 		if ids, _ := ni.Assume(pod); len(ids) == 0 {
 			return ScoreMin
 		}
 	}
-	return option.Score
+	return ni.assumed[pod.UID].option.Score
 }
 
+// Allocate binds the pod to the option it's assumed with, which is already taken on GPUs. An option is
+// traded again on the current GPUs if the pod's assumption is gone, as it expired or the node changed
+// since the pod was filtered, or if it no longer matches the pod's request. It fails only if the pod no
+// longer fits the node.
 func (ni *NodeAllocator) Allocate(pod *v1.Pod) (ids GPUIDs, err error) {
-	req := NewGPURequest(pod, ni.CoreName, ni.MemName)
-	key := req.Hash()
-	defer func() {
-		delete(ni.allocated, key)
-	}()
-	option, ok := ni.allocated[key]
-	if !ok {
-		return nil, fmt.Errorf("cannot find option of GPU request %+v on %+v", req, ni.GPUs)
+	if _, ok := ni.podsMap[pod.UID]; ok {
+		return nil, withReason(reasonAlreadyBound, fmt.Errorf("pod %s/%s is already allocated on node %s", pod.Namespace, pod.Name, ni.Node.Name))
 	}
-
-	klog.V(5).Infof("Pod %s/%s allocated option: %+v", pod.Namespace, pod.Name, option)
-	if err := ni.Add(pod, option); err != nil {
-		return nil, err
+	if _, ok := ni.assumed[pod.UID]; !ok {
+		klog.V(3).Infof("Assumption of pod %s/%s on node %s is gone, trade it again", pod.Namespace, pod.Name, ni.Node.Name)
+	}
+	if _, err := ni.Assume(pod); err != nil {
+		return nil, fmt.Errorf("pod %s/%s no longer fits node %s: %w", pod.Namespace, pod.Name, ni.Node.Name, err)
 	}
+	a := ni.assumed[pod.UID]
+	delete(ni.assumed, pod.UID)
 
-	return option.Allocated, nil
+	klog.V(5).Infof("Pod %s/%s allocated option: %+v", pod.Namespace, pod.Name, a.option)
+	ids = ni.GPUs.DeviceIndexes(a.option.Allocated)
+	// the pod is kept as it's bound, so that it's added the same way when the allocator is rebuilt
+	bound := GetUpdatedPodAnnotationSpec(pod, ids)
+	bound.Spec.NodeName = ni.Node.Name
+	ni.podsMap[pod.UID] = bound
+
+	return ids, nil
 }
 
 //
@@ -128,33 +309,144 @@ func (ni *NodeAllocator) Allocate(pod *v1.Pod) (ids GPUIDs, err error) {
 
 func (ni *NodeAllocator) Forget(pod *v1.Pod) error {
 	klog.V(5).Infof("Start to forget pod %s/%s, allocation cache: %+v", pod.Namespace, pod.Name, ni.podsMap)
-	if _, ok := ni.podsMap[pod.UID]; ok {
-		option := NewGPUOptionFromPod(pod, ni.CoreName, ni.MemName)
-		klog.V(5).Infof("Cancel pod %s/%s option %+v on %+v", pod.Namespace, pod.Name, option, ni.GPUs)
-		ni.GPUs.Cancel(option)
-		klog.V(5).Infof("Current GPU allocation of node %s: %+v", ni.Node.Name, ni.GPUs)
+	ni.Unassume(pod)
+	// the pod of a deletion may have lost its annotations, the option is read from the pod added
+	if known, ok := ni.podsMap[pod.UID]; ok {
+		if option, err := ni.optionFromPod(known); err != nil {
+			klog.Warningf("Failed to read the gpus of pod %s/%s on node %s, they are held until the node is reconciled: %v", pod.Namespace, pod.Name, ni.Node.Name, err)
+		} else {
+			klog.V(5).Infof("Cancel pod %s/%s option %+v on %+v", pod.Namespace, pod.Name, option, ni.GPUs)
+			ni.GPUs.Cancel(option)
+			klog.V(5).Infof("Current GPU allocation of node %s: %+v", ni.Node.Name, ni.GPUs)
+		}
 		delete(ni.podsMap, pod.UID)
+		ni.release(pod.UID)
+		ni.readmit()
+	} else if _, ok := ni.overcommitted[pod.UID]; ok {
+		delete(ni.overcommitted, pod.UID)
+		ni.release(pod.UID)
 	}
 
 	return nil
 }
 
+// readmit adds the overcommitted pods which fit the GPUs given back, oldest first.
+func (ni *NodeAllocator) readmit() {
+	pods := make([]v1.Pod, 0, len(ni.overcommitted))
+	for _, pod := range ni.overcommitted {
+		pods = append(pods, *pod)
+	}
+	sortPods(pods)
+	for i := range pods {
+		pod := &pods[i]
+		if err := ni.Add(pod, nil); err == nil {
+			klog.Infof("Pod %s/%s fits the gpus of node %s again", pod.Namespace, pod.Name, ni.Node.Name)
+		}
+	}
+}
+
 //func (ni *NodeAllocator) Clean(request GPURequest) (option *GPUOption) {
 //	option = ni.allocated[request.Hash()]
 //	ni.allocated[request.Hash()] = nil
 //	return
 //}
 
+// optionFromPod returns the option in the pod's annotations, with device indexes mapped to positions
+// in GPUs.
+func (ni *NodeAllocator) optionFromPod(pod *v1.Pod) (*GPUOption, error) {
+	option, err := NewGPUOptionFromPod(pod, ni.CoreName, ni.MemName)
+	if err != nil {
+		return nil, err
+	}
+	positions, err := ni.GPUs.Positions(option.Allocated)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gpu annotations of pod %s/%s on node %s: %v", pod.Namespace, pod.Name, ni.Node.Name, err)
+	}
+	option.Allocated = positions
+	return option, nil
+}
+
+// Add takes the option of the pod on the GPUs, or the option in its annotations if nil, in place of
+// the option the pod is assumed with. A new option fails if its devices are held by another mode,
+// while a pod already bound to them is accounted anyway. A bound pod whose option doesn't fit the
+// GPUs, as the node has fewer or smaller GPUs than when it was bound, is kept as overcommitted.
 func (ni *NodeAllocator) Add(pod *v1.Pod, option *GPUOption) error {
 	if _, ok := ni.podsMap[pod.UID]; !ok {
-		ni.podsMap[pod.UID] = pod
-		if option == nil {
-			option = NewGPUOptionFromPod(pod, ni.CoreName, ni.MemName)
+		ni.Unassume(pod)
+		bound := option == nil
+		if bound {
+			var err error
+			if option, err = NewGPUOptionFromPod(pod, ni.CoreName, ni.MemName); err != nil {
+				return err
+			}
+			if option.Allocated, err = ni.GPUs.Positions(option.Allocated); err != nil {
+				ni.overcommitted[pod.UID] = pod
+				return fmt.Errorf("pod %s/%s is bound to gpus not found on node %s: %v", pod.Namespace, pod.Name, ni.Node.Name, err)
+			}
+		}
+		if err := ni.claim(pod, option); err != nil {
+			if !bound {
+				return err
+			}
+			klog.Errorf("Pod %s/%s shares gpus with another mode: %v", pod.Namespace, pod.Name, err)
 		}
 
 		klog.V(5).Infof("Add pod %s/%s option: %+v", pod.Namespace, pod.Name, option)
-		return ni.GPUs.Transact(option)
+		if err := ni.GPUs.Transact(option); err != nil {
+			if !bound {
+				ni.release(pod.UID)
+				return err
+			}
+			ni.overcommitted[pod.UID] = pod
+			return fmt.Errorf("pod %s/%s exceeds the gpus of node %s: %v", pod.Namespace, pod.Name, ni.Node.Name, err)
+		}
+		delete(ni.overcommitted, pod.UID)
+		ni.podsMap[pod.UID] = pod
 	}
 
 	return nil
 }
+
+// claim records the devices of the option as held by the mode of the allocator in the ledger.
+func (ni *NodeAllocator) claim(pod *v1.Pod, option *GPUOption) error {
+	if ni.Ledger == nil {
+		return nil
+	}
+	devices := make([]int, 0)
+	for i, usage := range ni.GPUs.footprint(option) {
+		if !usage.empty() {
+			devices = append(devices, ni.GPUs[i].Index)
+		}
+	}
+	return ni.Ledger.Claim(ni.Node.Name, ni.CoreName, pod.UID, devices)
+}
+
+// release drops the claims of the pod in the ledger.
+func (ni *NodeAllocator) release(uid types.UID) {
+	if ni.Ledger != nil {
+		ni.Ledger.Release(ni.Node.Name, uid)
+	}
+}
+
+// tradableGPUs returns the GPUs options are chosen from, the devices held by other modes are
+// copied as fully used.
+func (ni *NodeAllocator) tradableGPUs() GPUs {
+	if ni.Ledger == nil {
+		return ni.GPUs
+	}
+	held := ni.Ledger.HeldByOthers(ni.Node.Name, ni.CoreName)
+	if len(held) == 0 {
+		return ni.GPUs
+	}
+	gpus := make(GPUs, len(ni.GPUs))
+	for i, gpu := range ni.GPUs {
+		gpus[i] = gpu
+		if containsInt(held, gpu.Index) {
+			used := *gpu
+			used.CoreAvailable = 0
+			used.MemoryAvailable = 0
+			gpus[i] = &used
+		}
+	}
+	return gpus
+}
@@ -0,0 +1,263 @@
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAssumeHoldsCapacity(t *testing.T) {
+	ni, err := NewNodeAllocator(nil, newTopologyNode(1, ""), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := newModePod("first", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+	second := newModePod("second", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+
+	if _, err := ni.Assume(&first); err != nil {
+		t.Fatal(err)
+	}
+	if ni.GPUs[0].CoreAvailable != 40 || ni.GPUs[0].MemoryAvailable != 8 {
+		t.Fatalf("expected the assumed pod taken on the gpu, got %s", ni.GPUs)
+	}
+	// assumed again with the same request, nothing more is taken
+	if ids, err := ni.Assume(&first); err != nil || !reflect.DeepEqual(ids, GPUIDs{{0}}) {
+		t.Fatalf("expected the same option, got %v, %v", ids, err)
+	}
+	if ni.GPUs[0].CoreAvailable != 40 {
+		t.Fatalf("expected the option taken once, got %s", ni.GPUs)
+	}
+	// a pod of the same shape can't be promised the same gpu
+	if ids, err := ni.Assume(&second); err == nil {
+		t.Fatalf("expected no option for the second pod, got %v", ids)
+	}
+
+	// a changed request replaces the option
+	first.Annotations = map[string]string{utils.AnnotationEGPUPolicy: "spread"}
+	if _, err := ni.Assume(&first); err != nil {
+		t.Fatal(err)
+	}
+	if ni.GPUs[0].CoreAvailable != 40 {
+		t.Fatalf("expected the option replaced, got %s", ni.GPUs)
+	}
+
+	ni.Unassume(&first)
+	if ni.GPUs[0].CoreAvailable != 100 || ni.GPUs[0].MemoryAvailable != 16 {
+		t.Fatalf("expected the gpu given back, got %s", ni.GPUs)
+	}
+	if _, err := ni.Assume(&second); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := ni.Allocate(&second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ni.GPUs[0].CoreAvailable != 40 {
+		t.Fatalf("expected the allocated option taken once, got %s", ni.GPUs)
+	}
+	if _, err := ni.Allocate(&second); err == nil {
+		t.Fatal("expected no option of a pod already allocated")
+	}
+
+	// the bound pod replaces its assumption
+	if _, err := ni.Assume(&first); err == nil {
+		t.Fatal("expected no option for the first pod")
+	}
+	third := newModePod("third", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "20", "4")
+	if _, err := ni.Assume(&third); err != nil {
+		t.Fatal(err)
+	}
+	bound := GetUpdatedPodAnnotationSpec(&third, GPUIDs{{0}})
+	if err := ni.Add(bound, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ni.GPUs[0].CoreAvailable != 20 || ni.GPUs[0].MemoryAvailable != 4 {
+		t.Fatalf("expected the bound pod taken once, got %s", ni.GPUs)
+	}
+	if err := ni.Forget(GetUpdatedPodAnnotationSpec(&second, ids)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ni.Forget(bound); err != nil {
+		t.Fatal(err)
+	}
+	if ni.GPUs[0].CoreAvailable != 100 || ni.GPUs[0].MemoryAvailable != 16 {
+		t.Fatalf("expected the gpu released, got %s", ni.GPUs)
+	}
+}
+
+func TestForgetPodWithoutAnnotations(t *testing.T) {
+	ni, err := NewNodeAllocator(nil, newTopologyNode(1, ""), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := newModePod("stripped", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+	if err := ni.Add(GetUpdatedPodAnnotationSpec(&pod, GPUIDs{{0}}), nil); err != nil {
+		t.Fatal(err)
+	}
+	// the deleted pod carries no gpu annotations, the gpus of the pod added are given back
+	if err := ni.Forget(&pod); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ni.podsMap[pod.UID]; ok || ni.GPUs[0].CoreAvailable != 100 || ni.GPUs[0].MemoryAvailable != 16 {
+		t.Fatalf("expected the pod forgotten and the gpu released, got %s", ni.GPUs)
+	}
+}
+
+func TestReadmitOldestFirst(t *testing.T) {
+	now := time.Now()
+	bound := func(name string, age time.Duration) *v1.Pod {
+		pod := newModePod(name, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+		pod.CreationTimestamp = metav1.NewTime(now.Add(-age))
+		return GetUpdatedPodAnnotationSpec(&pod, GPUIDs{{0}})
+	}
+	// the newer pod sorts first by name, only its age keeps it behind
+	holder, older, newer := bound("holder", 3*time.Hour), bound("older", 2*time.Hour), bound("a-newer", time.Hour)
+	for i := 0; i < 20; i++ {
+		ni, err := NewNodeAllocator([]v1.Pod{*holder, *older, *newer}, newTopologyNode(1, ""), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if overcommitted := ni.Overcommitted(); len(overcommitted) != 2 {
+			t.Fatalf("expected the two newer pods overcommitted, got %v", overcommitted)
+		}
+		// the gpu given back fits one of them, the older one takes it
+		if err := ni.Forget(holder); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := ni.podsMap[older.UID]; !ok || !reflect.DeepEqual(ni.Overcommitted(), []string{"/" + newer.Name}) {
+			t.Fatalf("expected the older pod readmitted, got overcommitted %v", ni.Overcommitted())
+		}
+	}
+}
+
+func TestExpireAssumptions(t *testing.T) {
+	ni, err := NewNodeAllocator(nil, newTopologyNode(2, ""), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := newModePod("stale", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "100", "0")
+	if _, err := ni.Assume(&stale); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	fresh := newModePod("fresh", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "20", "4")
+	if _, err := ni.Assume(&fresh); err != nil {
+		t.Fatal(err)
+	}
+	if ni.Assumed() != 2 {
+		t.Fatalf("expected 2 assumptions, got %d", ni.Assumed())
+	}
+
+	if expired := ni.Expire(before); expired != 1 {
+		t.Fatalf("expected 1 assumption expired, got %d", expired)
+	}
+	if ni.Assumed() != 1 || len(ni.GPUs.GetFreeGPUs()) != 1 {
+		t.Fatalf("expected the stale assumption given back, got %s", ni.GPUs)
+	}
+	// bound anyway, the expired pod is given an option again
+	ids, err := ni.Allocate(&stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ni.Forget(GetUpdatedPodAnnotationSpec(&stale, ids)); err != nil {
+		t.Fatal(err)
+	}
+
+	// filtered again, the pod is assumed anew
+	again := time.Now()
+	if _, err := ni.Assume(&fresh); err != nil {
+		t.Fatal(err)
+	}
+	if expired := ni.Expire(again); expired != 0 {
+		t.Fatalf("expected no assumption expired, got %d", expired)
+	}
+	if expired := ni.Expire(time.Now().Add(time.Second)); expired != 1 || ni.Assumed() != 0 {
+		t.Fatalf("expected the last assumption expired, got %d", expired)
+	}
+	if len(ni.GPUs.GetFreeGPUs()) != 2 {
+		t.Fatalf("expected all gpus free, got %s", ni.GPUs)
+	}
+}
+
+func TestUpdateNode(t *testing.T) {
+	config := ElasticSchedulerConfig{Rater: &Binpack{}, Ledger: NewDeviceLedger()}
+	d := &GPUUnitScheduler{BaseScheduler: newBaseScheduler(config, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)}
+	node := newTopologyNode(1, "")
+	ni, err := newNodeAllocator(nil, node, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{}, config.Ledger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.nodeMaps[node.Name] = ni
+
+	older := newModePod("older", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "50", "6")
+	older.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	newer := newModePod("newer", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "50", "6")
+	newer.CreationTimestamp = metav1.NewTime(time.Now())
+	for _, pod := range []*v1.Pod{&newer, &older} {
+		if _, err := ni.Assume(pod); err != nil {
+			t.Fatal(err)
+		}
+		ids, err := ni.Allocate(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		*pod = *GetUpdatedPodAnnotationSpec(pod, ids)
+		pod.Spec.NodeName = node.Name
+	}
+
+	// nothing changed but the labels
+	updated := node.DeepCopy()
+	updated.Labels = map[string]string{"foo": "bar"}
+	if err := d.UpdateNode(updated); err != nil {
+		t.Fatal(err)
+	}
+	if d.nodeMaps[node.Name] != ni || ni.Node != updated {
+		t.Fatal("expected the allocation kept")
+	}
+
+	// the gpu has memory for one of the pods left, the older pod keeps it
+	shrunk := node.DeepCopy()
+	shrunk.Status.Allocatable[v1alpha1.ResourceGPUMemory] = resource.MustParse("8")
+	if err := d.UpdateNode(shrunk); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt := d.nodeMaps[node.Name]
+	if rebuilt == ni || rebuilt.Node != shrunk {
+		t.Fatal("expected the allocation rebuilt")
+	}
+	if rebuilt.GPUs[0].CoreAvailable != 50 || rebuilt.GPUs[0].MemoryAvailable != 2 {
+		t.Fatalf("expected the older pod on the gpu, got %s", rebuilt.GPUs)
+	}
+	statuses := d.NodeStatuses()
+	if !reflect.DeepEqual(statuses[node.Name].Overcommitted, []string{"/" + newer.Name}) {
+		t.Fatalf("expected the newer pod overcommitted, got %+v", statuses)
+	}
+
+	// the newer pod gets its gpu back once the older one is gone
+	if err := d.ForgetPod(&older); err != nil {
+		t.Fatal(err)
+	}
+	if len(rebuilt.Overcommitted()) != 0 || rebuilt.GPUs[0].CoreAvailable != 50 || rebuilt.GPUs[0].MemoryAvailable != 2 {
+		t.Fatalf("expected the newer pod added, got %v, %s", rebuilt.Overcommitted(), rebuilt.GPUs)
+	}
+	if held := config.Ledger.HeldByOthers(node.Name, v1alpha1.ResourceQGPUCore); !reflect.DeepEqual(held, []int{0}) {
+		t.Fatalf("expected gpu [0] held, got %v", held)
+	}
+
+	d.RemoveNode(node.Name)
+	if _, ok := d.nodeMaps[node.Name]; ok {
+		t.Fatal("expected the node removed")
+	}
+	if held := config.Ledger.HeldByOthers(node.Name, v1alpha1.ResourceQGPUCore); len(held) != 0 {
+		t.Fatalf("expected no gpu held, got %v", held)
+	}
+	if err := d.ForgetPod(&newer); err != nil {
+		t.Fatal(err)
+	}
+}
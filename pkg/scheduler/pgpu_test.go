@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newPGPUPod(name string, count string) v1.Pod {
+	pod := generatePods(name, 1)[0]
+	pod.UID = types.UID(name)
+	pod.Spec.Containers[0].Name = "main"
+	pod.Spec.Containers[0].Resources.Requests = v1.ResourceList{
+		v1alpha1.ResourcePGPU: resource.MustParse(count),
+	}
+	return pod
+}
+
+func TestNewGPURequestPGPU(t *testing.T) {
+	pod := newPGPUPod("pgpu", "2")
+	request, err := NewGPURequest(&pod, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(request, GPURequest{{GPUCount: 2}}) {
+		t.Fatalf("expected 2 whole gpus, got %v", request)
+	}
+
+	pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUMemory] = resource.MustParse("4")
+	if _, err := NewGPURequest(&pod, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory); err == nil || !strings.Contains(err.Error(), "requests both") {
+		t.Fatalf("expected error for pgpu with gpu memory, got %v", err)
+	}
+
+	pod = newPGPUPod("pgpu", "1500m")
+	if _, err := NewGPURequest(&pod, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory); err == nil {
+		t.Fatal("expected error for a fractional number of gpus")
+	}
+}
+
+func TestPGPUWithGPUShare(t *testing.T) {
+	ni, err := NewNodeAllocator(nil, newTopologyNode(4, ""), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	share := generatePods("share", 1)[0]
+	share.UID = types.UID("share")
+	share.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUCore] = resource.MustParse("20")
+	if _, err := ni.Assume(&share); err != nil {
+		t.Fatal(err)
+	}
+	shareIDs, err := ni.Allocate(&share)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the gpu shared by the gpushare pod can't be taken by a pgpu pod
+	all := newPGPUPod("pgpu-all", "4")
+	if _, err := ni.Assume(&all); err == nil {
+		t.Fatal("expected no room for 4 physical gpus")
+	}
+	pod := newPGPUPod("pgpu", "3")
+	if _, err := ni.Assume(&pod); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := ni.Allocate(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[0] {
+		if id == shareIDs[0][0] {
+			t.Fatalf("pgpu pod got gpu %d of the gpushare pod", id)
+		}
+	}
+
+	// a new allocator of the node accounts the bound pods of both modes
+	bound := []v1.Pod{*GetUpdatedPodAnnotationSpec(&share, shareIDs), *GetUpdatedPodAnnotationSpec(&pod, ids)}
+	rebuilt, err := NewNodeAllocator(bound, newTopologyNode(4, ""), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt.GPUs.String() != ni.GPUs.String() {
+		t.Fatalf("expected the rebuilt allocation %s, got %s", ni.GPUs, rebuilt.GPUs)
+	}
+	other := generatePods("share-other", 1)[0]
+	other.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUCore] = resource.MustParse("90")
+	if _, err := rebuilt.Assume(&other); err == nil {
+		t.Fatal("expected no room for another gpushare pod of 90 core")
+	}
+}
+
+func TestPGPUOnlyNode(t *testing.T) {
+	node := &v1.Node{
+		Status: v1.NodeStatus{
+			Allocatable: v1.ResourceList{v1alpha1.ResourcePGPU: resource.MustParse("2")},
+		},
+	}
+	node.Name = "pgpu"
+	ni, err := NewNodeAllocator(nil, node, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ni.GPUs) != 2 || ni.GPUs[0].CoreTotal != utils.GPUCoreEachCard {
+		t.Fatalf("expected 2 whole gpus, got %s", ni.GPUs)
+	}
+	pod := newPGPUPod("pgpu", "2")
+	ids, err := ni.Assume(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, GPUIDs{{0, 1}}) {
+		t.Fatalf("expected gpus [[0 1]], got %v", ids)
+	}
+}
@@ -2,10 +2,13 @@ package scheduler
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
@@ -34,7 +37,7 @@ func IsGPUPod(pod *v1.Pod) bool {
 }
 
 func IsResourceExists(pod *v1.Pod, resourceName v1.ResourceName) bool {
-	for _, container := range pod.Spec.Containers {
+	for _, container := range GetPodContainers(pod) {
 		if _, ok := container.Resources.Limits[resourceName]; ok {
 			return true
 		}
@@ -42,15 +45,15 @@ func IsResourceExists(pod *v1.Pod, resourceName v1.ResourceName) bool {
 	return false
 }
 
-func GetResourceRequests(pod *v1.Pod, resourceName v1.ResourceName) uint {
-	containers := pod.Spec.Containers
-	requests := uint(0)
-	for _, container := range containers {
-		if val, ok := container.Resources.Limits[resourceName]; ok {
-			requests += uint(val.Value())
-		}
+// GetPodContainers returns the app containers of the pod followed by its init containers. Ephemeral
+// containers can't request resources and are never given GPUs.
+func GetPodContainers(pod *v1.Pod) []v1.Container {
+	if len(pod.Spec.InitContainers) == 0 {
+		return pod.Spec.Containers
 	}
-	return requests
+	containers := make([]v1.Container, 0, len(pod.Spec.Containers)+len(pod.Spec.InitContainers))
+	containers = append(containers, pod.Spec.Containers...)
+	return append(containers, pod.Spec.InitContainers...)
 }
 
 // GetUpdatedPodAnnotationSpec updates pod annotation with devId
@@ -62,8 +65,8 @@ func GetUpdatedPodAnnotationSpec(oldPod *v1.Pod, ids [][]int) (newPod *v1.Pod) {
 	if len(newPod.Annotations) == 0 {
 		newPod.Annotations = map[string]string{}
 	}
-	for i, container := range newPod.Spec.Containers {
-		if ids[i][0] == NotNeedGPU {
+	for i, container := range GetPodContainers(newPod) {
+		if i >= len(ids) || len(ids[i]) == 0 || ids[i][0] == NotNeedGPU {
 			continue
 		}
 		var idsStr []string
@@ -77,6 +80,61 @@ func GetUpdatedPodAnnotationSpec(oldPod *v1.Pod, ids [][]int) (newPod *v1.Pod) {
 	return newPod
 }
 
+// GetPodAnnotationPatch returns the merge patch which sets the annotations and labels of
+// GetUpdatedPodAnnotationSpec on the pod. It fails on a pod recreated with the same name, as the uid
+// of a pod can't be changed.
+func GetPodAnnotationPatch(pod *v1.Pod, ids [][]int) ([]byte, error) {
+	newPod := GetUpdatedPodAnnotationSpec(pod, ids)
+	annotations := map[string]string{}
+	for k, v := range newPod.Annotations {
+		if old, ok := pod.Annotations[k]; !ok || old != v {
+			annotations[k] = v
+		}
+	}
+	labels := map[string]string{}
+	for k, v := range newPod.Labels {
+		if old, ok := pod.Labels[k]; !ok || old != v {
+			labels[k] = v
+		}
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":         pod.UID,
+			"annotations": annotations,
+			"labels":      labels,
+		},
+	})
+}
+
+// GetPodAnnotationRollbackPatch returns the merge patch undoing the one of GetPodAnnotationPatch, the
+// annotations and labels it sets are given back their old values or removed.
+func GetPodAnnotationRollbackPatch(pod *v1.Pod, ids [][]int) ([]byte, error) {
+	newPod := GetUpdatedPodAnnotationSpec(pod, ids)
+	annotations := map[string]interface{}{}
+	for k, v := range newPod.Annotations {
+		if old, ok := pod.Annotations[k]; !ok {
+			annotations[k] = nil
+		} else if old != v {
+			annotations[k] = old
+		}
+	}
+	labels := map[string]interface{}{}
+	for k, v := range newPod.Labels {
+		if old, ok := pod.Labels[k]; !ok {
+			labels[k] = nil
+		} else if old != v {
+			labels[k] = old
+		}
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":         pod.UID,
+			"annotations": annotations,
+			"labels":      labels,
+		},
+	})
+}
+
 func IsAssumed(pod *v1.Pod) bool {
 	return pod.ObjectMeta.Annotations[utils.EGPUAssumed] == "true"
 }
@@ -99,15 +157,29 @@ func GetGPUCoreFromContainer(container *v1.Container, resource v1.ResourceName)
 	return int(val.Value())
 }
 
-func GetGPUMemoryFromContainer(container *v1.Container, resource v1.ResourceName) int {
+// GetPGPUFromContainer returns the number of whole physical GPUs the container requests.
+func GetPGPUFromContainer(container *v1.Container) (int, error) {
+	val, ok := container.Resources.Requests[v1alpha1.ResourcePGPU]
+	if !ok {
+		return 0, nil
+	}
+	if val.Sign() < 0 || val.Cmp(*resource.NewQuantity(val.Value(), val.Format)) != 0 || val.Value() > math.MaxInt32 {
+		return 0, fmt.Errorf("%s %s is not a valid number of gpus", v1alpha1.ResourcePGPU, val.String())
+	}
+	return int(val.Value()), nil
+}
+
+// GetGPUMemoryFromContainer returns the gpu memory request of the container in the canonical unit,
+// see GPUMemoryFromQuantity.
+func GetGPUMemoryFromContainer(container *v1.Container, resource v1.ResourceName) (int, error) {
 	val, ok := container.Resources.Requests[resource]
 	if !ok {
-		return 0
+		return 0, nil
 	}
-	return int(val.Value())
+	return GPUMemoryFromQuantity(val)
 }
 
-func GetPod(ctx context.Context, name string, namespace string, podUID types.UID, clientset *kubernetes.Clientset) (pod *v1.Pod, err error) {
+func GetPod(ctx context.Context, name string, namespace string, podUID types.UID, clientset kubernetes.Interface) (pod *v1.Pod, err error) {
 	pod, err = clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
@@ -129,26 +201,3 @@ func GetPod(ctx context.Context, name string, namespace string, podUID types.UID
 
 	return pod, nil
 }
-
-func GetContainerGPUResource(pod v1.Pod) map[string]GPUUnit {
-	maps := make(map[string]GPUUnit)
-	for _, container := range pod.Spec.Containers {
-		if container.Name == "" {
-			continue
-		}
-		unit := GPUUnit{}
-		core1, _ := container.Resources.Requests[v1alpha1.ResourceGPUCore]
-		core2, _ := container.Resources.Requests[v1alpha1.ResourceQGPUCore]
-		if int(core1.Value()) >= utils.GPUCoreEachCard || int(core2.Value()) >= utils.GPUCoreEachCard {
-			unit.GPUCount += int(core1.Value())/utils.GPUCoreEachCard + int(core2.Value())/utils.GPUCoreEachCard
-			continue
-		}
-		unit.Core += int(core1.Value() + core2.Value())
-		mem1, _ := container.Resources.Requests[v1alpha1.ResourceGPUMemory]
-		mem2, _ := container.Resources.Requests[v1alpha1.ResourceQGPUMemory]
-		unit.Memory += int(mem1.Value() + mem2.Value())
-		maps[container.Name] = unit
-	}
-
-	return maps
-}
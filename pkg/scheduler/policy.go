@@ -0,0 +1,71 @@
+package scheduler
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"sigs.k8s.io/yaml"
+)
+
+// PolicyConfig describes how candidate GPU allocations are rated. It is loaded from the file passed
+// by the -config flag, e.g.
+//
+//	raters:
+//	- name: binpack
+//	  weight: 2
+//	- name: fragmentation
+//	  weight: 1
+type PolicyConfig struct {
+	Raters []RaterConfig `json:"raters"`
+}
+
+type RaterConfig struct {
+	// Name is the name the rater is registered with, see RegisterRater.
+	Name string `json:"name"`
+	// Weight of the rater in the composite score, defaults to 1.
+	Weight int `json:"weight,omitempty"`
+}
+
+func LoadPolicyConfig(path string) (*PolicyConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy config %s: %v", path, err)
+	}
+	config := &PolicyConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse policy config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// NewPolicyConfig returns a policy using the single named rater.
+func NewPolicyConfig(name string) *PolicyConfig {
+	return &PolicyConfig{Raters: []RaterConfig{{Name: name, Weight: 1}}}
+}
+
+// BuildRater builds the rater described by the policy. A policy with a single rater uses it as-is,
+// otherwise the raters are combined into a CompositeRater.
+func (c *PolicyConfig) BuildRater() (Rater, error) {
+	if len(c.Raters) == 0 {
+		return nil, fmt.Errorf("policy config has no raters")
+	}
+	weighted := make([]WeightedRater, 0, len(c.Raters))
+	for _, rc := range c.Raters {
+		rater, err := NewRater(rc.Name)
+		if err != nil {
+			return nil, err
+		}
+		weight := rc.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("weight of rater %q must be positive, got %d", rc.Name, weight)
+		}
+		weighted = append(weighted, WeightedRater{Name: rc.Name, Rater: rater, Weight: weight})
+	}
+	if len(weighted) == 1 {
+		return weighted[0].Rater, nil
+	}
+	return NewCompositeRater(weighted...)
+}
@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// newMixedModeNode returns a node with 2 GPUs for gpushare and 2 GPUs for qgpu.
+func newMixedModeNode() *v1.Node {
+	node := &v1.Node{
+		Status: v1.NodeStatus{
+			Allocatable: v1.ResourceList{
+				v1alpha1.ResourceGPUCore:    resource.MustParse("200"),
+				v1alpha1.ResourceGPUMemory:  resource.MustParse("32"),
+				v1alpha1.ResourceQGPUCore:   resource.MustParse("200"),
+				v1alpha1.ResourceQGPUMemory: resource.MustParse("32"),
+			},
+		},
+	}
+	node.Name = "mixed-mode"
+	return node
+}
+
+func newModePod(name string, core, mem v1.ResourceName, coreRequest, memRequest string) v1.Pod {
+	pod := generatePods(name, 1)[0]
+	pod.UID = types.UID(name)
+	pod.Spec.Containers[0].Name = "main"
+	pod.Spec.Containers[0].Resources.Requests = v1.ResourceList{
+		core: resource.MustParse(coreRequest),
+		mem:  resource.MustParse(memRequest),
+	}
+	return pod
+}
+
+func TestNewGPURequestQGPU(t *testing.T) {
+	pod := newModePod("qgpu", v1alpha1.ResourceQGPUCore, v1alpha1.ResourceQGPUMemory, "30", "4")
+	request, err := NewGPURequest(&pod, v1alpha1.ResourceQGPUCore, v1alpha1.ResourceQGPUMemory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(request, GPURequest{{Core: 30, Memory: 4}}) {
+		t.Fatalf("unexpected qgpu request %v", request)
+	}
+	request, err = NewGPURequest(&pod, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(request, GPURequest{{Core: NotNeedGPU, Memory: NotNeedGPU}}) {
+		t.Fatalf("expected no gpushare request of a qgpu pod, got %v", request)
+	}
+}
+
+func TestGPUShareAndQGPUIsolation(t *testing.T) {
+	node := newMixedModeNode()
+	share, err := NewNodeAllocator(nil, node, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	qgpu, err := NewNodeAllocator(nil, node, v1alpha1.ResourceQGPUCore, v1alpha1.ResourceQGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharePod := newModePod("share", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+	qgpuPod := newModePod("qgpu", v1alpha1.ResourceQGPUCore, v1alpha1.ResourceQGPUMemory, "30", "4")
+	bind := func(ni *NodeAllocator, pod *v1.Pod) *v1.Pod {
+		if _, err := ni.Assume(pod); err != nil {
+			t.Fatal(err)
+		}
+		ids, err := ni.Allocate(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return GetUpdatedPodAnnotationSpec(pod, ids)
+	}
+	boundShare := bind(share, &sharePod)
+	boundQGPU := bind(qgpu, &qgpuPod)
+	shareState, qgpuState := share.GPUs.String(), qgpu.GPUs.String()
+
+	// pods of the other mode, with their annotations, change nothing
+	if err := share.Add(boundQGPU, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := qgpu.Add(boundShare, nil); err != nil {
+		t.Fatal(err)
+	}
+	if share.GPUs.String() != shareState || qgpu.GPUs.String() != qgpuState {
+		t.Fatalf("adding pods of the other mode changed the allocation: gpushare %s, qgpu %s", share.GPUs, qgpu.GPUs)
+	}
+	if err := share.Forget(boundQGPU); err != nil {
+		t.Fatal(err)
+	}
+	if err := qgpu.Forget(boundShare); err != nil {
+		t.Fatal(err)
+	}
+	if share.GPUs.String() != shareState || qgpu.GPUs.String() != qgpuState {
+		t.Fatalf("forgetting pods of the other mode changed the allocation: gpushare %s, qgpu %s", share.GPUs, qgpu.GPUs)
+	}
+
+	// allocators rebuilt from all the bound pods of the node only account their own
+	pods := []v1.Pod{*boundShare, *boundQGPU}
+	rebuiltShare, err := NewNodeAllocator(pods, node, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuiltQGPU, err := NewNodeAllocator(pods, node, v1alpha1.ResourceQGPUCore, v1alpha1.ResourceQGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuiltShare.GPUs.String() != shareState || rebuiltQGPU.GPUs.String() != qgpuState {
+		t.Fatalf("rebuilt allocation differs: gpushare %s, qgpu %s", rebuiltShare.GPUs, rebuiltQGPU.GPUs)
+	}
+
+	// releasing the pod of one mode leaves the other one allocated
+	if err := share.Forget(boundShare); err != nil {
+		t.Fatal(err)
+	}
+	if share.GPUs[0].CoreAvailable != 100 || share.GPUs[1].CoreAvailable != 100 {
+		t.Fatalf("expected gpushare gpus released, got %s", share.GPUs)
+	}
+	if qgpu.GPUs.String() != qgpuState {
+		t.Fatalf("releasing the gpushare pod changed qgpu allocation to %s", qgpu.GPUs)
+	}
+}
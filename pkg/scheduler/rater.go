@@ -1,29 +1,159 @@
 package scheduler
 
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+)
+
 const (
+	// ScoreMin and ScoreMax bound the scores of nodes sent to kube-scheduler, its
+	// MaxExtenderPriority.
 	ScoreMin = 0
 	ScoreMax = 10
+
+	// NormalizedScoreMax is the upper bound of scores given by normalized raters such as Spread.
+	NormalizedScoreMax = 100
 )
 
+// extenderScore maps the score of a rater to ScoreMin..ScoreMax, scores out of
+// 0..NormalizedScoreMax are clamped.
+func extenderScore(score int) int {
+	if score < 0 {
+		score = 0
+	}
+	if score > NormalizedScoreMax {
+		score = NormalizedScoreMax
+	}
+	return ScoreMin + score*(ScoreMax-ScoreMin)/NormalizedScoreMax
+}
+
 type Rater interface {
 	Rate(g GPUs, indexes []int) int
 }
 
+// RaterFactory creates a new instance of a named rater.
+type RaterFactory func() Rater
+
+var (
+	ratersLock sync.RWMutex
+	raters     = map[string]RaterFactory{}
+)
+
+func init() {
+	RegisterRater(utils.PriorityBinPack, func() Rater { return &Binpack{} })
+	RegisterRater(utils.PrioritySpread, func() Rater { return &Spread{} })
+	RegisterRater(utils.PriorityFragmentation, func() Rater { return &Fragmentation{} })
+	RegisterRater(utils.PriorityTopology, func() Rater { return &Topology{} })
+}
+
+// RegisterRater makes a rater available by name to policy configs. Registering a name twice
+// replaces the previous factory.
+func RegisterRater(name string, factory RaterFactory) {
+	ratersLock.Lock()
+	defer ratersLock.Unlock()
+	raters[name] = factory
+}
+
+// NewRater creates the rater registered under name.
+func NewRater(name string) (Rater, error) {
+	ratersLock.RLock()
+	defer ratersLock.RUnlock()
+	factory, ok := raters[name]
+	if !ok {
+		return nil, fmt.Errorf("rater %q is not registered, available raters: %v", name, registeredRaters())
+	}
+	return factory(), nil
+}
+
+// GetPodRater returns the rater named by the pod's policy annotation, or defaultRater when the pod
+// doesn't have the annotation.
+func GetPodRater(pod *v1.Pod, defaultRater Rater) (Rater, error) {
+	policy, ok := pod.Annotations[utils.AnnotationEGPUPolicy]
+	if !ok || policy == "" {
+		return defaultRater, nil
+	}
+	rater, err := NewRater(policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation of pod %s/%s: %v", utils.AnnotationEGPUPolicy, pod.Namespace, pod.Name, err)
+	}
+	return rater, nil
+}
+
+func registeredRaters() []string {
+	names := make([]string, 0, len(raters))
+	for name := range raters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WeightedRater is a rater with its weight in a CompositeRater.
+type WeightedRater struct {
+	Name   string
+	Rater  Rater
+	Weight int
+}
+
+// CompositeRater combines several raters into one, the score is the weighted average of the
+// scores of its raters.
+type CompositeRater struct {
+	Raters []WeightedRater
+}
+
+func NewCompositeRater(raters ...WeightedRater) (*CompositeRater, error) {
+	if len(raters) == 0 {
+		return nil, fmt.Errorf("composite rater needs at least one rater")
+	}
+	for _, r := range raters {
+		if r.Rater == nil {
+			return nil, fmt.Errorf("rater %q is nil", r.Name)
+		}
+		if r.Weight <= 0 {
+			return nil, fmt.Errorf("weight of rater %q must be positive, got %d", r.Name, r.Weight)
+		}
+	}
+	return &CompositeRater{Raters: raters}, nil
+}
+
+func (c *CompositeRater) Rate(g GPUs, indexes []int) int {
+	score, weight := 0, 0
+	for _, r := range c.Raters {
+		score += r.Weight * r.Rater.Rate(g, indexes)
+		weight += r.Weight
+	}
+	if weight == 0 {
+		return ScoreMin
+	}
+	return score / weight
+}
+
 type SampleRater struct {
 }
 
+// Binpack prefers the options which leave the GPUs of the node unevenly used, so that used GPUs are
+// filled before free ones, and which take few GPUs. The spread of the core and memory left between
+// the GPUs is rated as a ratio of the largest GPU, so that scores are within 0..NormalizedScoreMax
+// and weigh like those of the other raters in a CompositeRater.
 type Binpack struct {
 }
 
 func (bp *Binpack) Rate(g GPUs, indexes []int) int {
+	if len(g) == 0 {
+		return ScoreMin
+	}
 	gpuIndex := make([]int, len(g))
 	gpuCount := 0
-	for _, g := range indexes {
-		if g < 0 {
+	for _, i := range indexes {
+		if i < 0 || i >= len(g) {
 			continue
 		}
-		if gpuIndex[g] == 0 {
-			gpuIndex[g]++
+		if gpuIndex[i] == 0 {
+			gpuIndex[i]++
 			gpuCount++
 		}
 	}
@@ -31,6 +161,7 @@ func (bp *Binpack) Rate(g GPUs, indexes []int) int {
 	minMemoryLeft := g[0].MemoryAvailable
 	maxCoreLeft := g[0].CoreAvailable
 	minCoreLeft := g[0].CoreAvailable
+	maxMemoryTotal, maxCoreTotal := 0, 0
 	for _, gpu := range g {
 		if gpu.MemoryAvailable > maxMemoryLeft {
 			maxMemoryLeft = gpu.MemoryAvailable
@@ -44,16 +175,162 @@ func (bp *Binpack) Rate(g GPUs, indexes []int) int {
 		if gpu.CoreAvailable < minCoreLeft {
 			minCoreLeft = gpu.CoreAvailable
 		}
+		if gpu.MemoryTotal > maxMemoryTotal {
+			maxMemoryTotal = gpu.MemoryTotal
+		}
+		if gpu.CoreTotal > maxCoreTotal {
+			maxCoreTotal = gpu.CoreTotal
+		}
+	}
+	spread := (ratio(maxCoreLeft-minCoreLeft, maxCoreTotal) + ratio(maxMemoryLeft-minMemoryLeft, maxMemoryTotal)) / 2
+	// an option on one GPU keeps the whole spread, each more GPU lowers it
+	if gpuCount == 0 {
+		gpuCount = 1
 	}
-	Range := (maxMemoryLeft + maxCoreLeft - minMemoryLeft - minCoreLeft) / 2
-	res := Range / (gpuCount + 1) * 100
-	return res
+	return int(spread * 2 / float64(gpuCount+1) * NormalizedScoreMax)
 }
 
+// Spread prefers the least loaded GPUs and nodes. The GPUs chosen for the pod are rated by their
+// remaining core and memory headroom and by how many tenants share them, and the node is rated by
+// its overall headroom, so that the same scores rank both options within a node and nodes against
+// each other.
 type Spread struct {
 }
 
+const (
+	spreadGPUWeight     = 0.5
+	spreadNodeWeight    = 0.5
+	spreadHeadroomShare = 0.7
+	spreadTenantShare   = 0.3
+)
+
 func (s *Spread) Rate(g GPUs, indexes []int) int {
-	// TODO
-	return 0
+	if len(g) == 0 {
+		return ScoreMin
+	}
+	nodeScore := g.FreeRatio()
+	gpuScore, rated := 0.0, 0
+	for n, i := range indexes {
+		if i < 0 || i >= len(g) || containsInt(indexes[:n], i) {
+			continue
+		}
+		rated++
+		gpuScore += spreadGPUScore(g[i])
+	}
+	if rated == 0 {
+		return int(nodeScore * NormalizedScoreMax)
+	}
+	gpuScore /= float64(rated)
+
+	return int((spreadGPUWeight*gpuScore + spreadNodeWeight*nodeScore) * NormalizedScoreMax)
+}
+
+func containsInt(array []int, value int) bool {
+	for _, v := range array {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func spreadGPUScore(gpu *GPU) float64 {
+	tenants := gpu.Tenants
+	if tenants < 1 {
+		tenants = 1
+	}
+	return spreadHeadroomShare*gpu.FreeRatio() + spreadTenantShare/float64(tenants)
+}
+
+// Bound relies on the score of a GPU only going down when more containers are placed on it: the
+// GPUs already chosen keep at most their current scores, each GPU chosen later scores at most as
+// it would with its current headroom and one more tenant, and the node headroom is reduced at
+// least by the fractional units left. The GPU part of the bound is the best average of the chosen
+// GPUs plus the k best other GPUs, for k up to the number of fractional units left.
+func (s *Spread) Bound(g GPUs, indexes []int, remaining GPURequest) int {
+	if len(g) == 0 {
+		return ScoreMin
+	}
+	coreAvailable, coreTotal, memAvailable, memTotal := 0, 0, 0, 0
+	for _, gpu := range g {
+		coreAvailable += gpu.CoreAvailable
+		coreTotal += gpu.CoreTotal
+		memAvailable += gpu.MemoryAvailable
+		memTotal += gpu.MemoryTotal
+	}
+	fractional := 0
+	for _, unit := range remaining {
+		if (unit.GPUCount > 0 && !unit.hasShare()) || (unit.Core == NotNeedGPU && unit.Memory == NotNeedGPU) {
+			continue
+		}
+		fractional++
+		coreAvailable -= unit.Core
+		if unit.GPUCount == 0 {
+			// the memory of a share depends on the whole GPUs chosen with it
+			memAvailable -= unit.Memory
+		}
+	}
+	nodeScore := (ratio(coreAvailable, coreTotal) + ratio(memAvailable, memTotal)) / 2
+
+	chosenScore, chosen := 0.0, 0
+	for n, i := range indexes {
+		if i < 0 || i >= len(g) || containsInt(indexes[:n], i) {
+			continue
+		}
+		chosen++
+		chosenScore += spreadGPUScore(g[i])
+	}
+	others := make([]float64, 0, len(g))
+	for i, gpu := range g {
+		if !containsInt(indexes, i) {
+			others = append(others, spreadHeadroomShare*gpu.FreeRatio()+spreadTenantShare/float64(gpu.Tenants+1))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(others)))
+
+	gpuScore, rated := 0.0, false
+	if chosen > 0 {
+		gpuScore, rated = chosenScore/float64(chosen), true
+	}
+	sum := chosenScore
+	for k := 1; k <= fractional && k <= len(others); k++ {
+		sum += others[k-1]
+		if score := sum / float64(chosen+k); !rated || score > gpuScore {
+			gpuScore, rated = score, true
+		}
+	}
+	if !rated {
+		return int(nodeScore * NormalizedScoreMax)
+	}
+	return int((spreadGPUWeight*gpuScore + spreadNodeWeight*nodeScore) * NormalizedScoreMax)
+}
+
+// Fragmentation prefers options that keep free resources on whole idle GPUs instead of leaving
+// them scattered over partially used GPUs, where they can only be used by small requests.
+type Fragmentation struct {
+}
+
+func (f *Fragmentation) Rate(g GPUs, indexes []int) int {
+	idle, fragments := 0.0, 0.0
+	for _, gpu := range g {
+		free := gpu.FreeRatio()
+		if free >= 1 {
+			idle++
+		} else {
+			fragments += free
+		}
+	}
+	if idle+fragments == 0 {
+		return NormalizedScoreMax
+	}
+	return int(idle / (idle + fragments) * NormalizedScoreMax)
+}
+
+func containsString(array []string, value string) bool {
+	for _, v := range array {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
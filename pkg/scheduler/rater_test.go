@@ -0,0 +1,339 @@
+package scheduler
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func newGPU(core, mem, coreTotal, memTotal, tenants int) *GPU {
+	return &GPU{
+		CoreAvailable:   core,
+		MemoryAvailable: mem,
+		CoreTotal:       coreTotal,
+		MemoryTotal:     memTotal,
+		Tenants:         tenants,
+	}
+}
+
+func TestSpreadRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		gpus    GPUs
+		better  []int
+		worse   []int
+		wantTie bool
+	}{
+		{
+			name:   "more headroom wins",
+			gpus:   GPUs{newGPU(90, 10, 100, 12, 1), newGPU(20, 2, 100, 12, 1)},
+			better: []int{0},
+			worse:  []int{1},
+		},
+		{
+			name:   "fewer tenants wins with equal headroom",
+			gpus:   GPUs{newGPU(50, 6, 100, 12, 1), newGPU(50, 6, 100, 12, 3)},
+			better: []int{0},
+			worse:  []int{1},
+		},
+		{
+			name:   "containers on different gpus beat sharing one gpu",
+			gpus:   GPUs{newGPU(60, 8, 100, 12, 1), newGPU(60, 8, 100, 12, 1), newGPU(20, 4, 100, 12, 2)},
+			better: []int{0, 1},
+			worse:  []int{2, 2},
+		},
+		{
+			name:    "whole gpu containers are rated by node headroom only",
+			gpus:    GPUs{newGPU(0, 0, 100, 12, 1), newGPU(100, 12, 100, 12, 0)},
+			better:  []int{NotNeedRate},
+			worse:   []int{NotNeedGPU},
+			wantTie: true,
+		},
+	}
+
+	rater := &Spread{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			better := rater.Rate(tt.gpus, tt.better)
+			worse := rater.Rate(tt.gpus, tt.worse)
+			for _, score := range []int{better, worse} {
+				if score < ScoreMin || score > NormalizedScoreMax {
+					t.Fatalf("score %d out of range [%d, %d]", score, ScoreMin, NormalizedScoreMax)
+				}
+			}
+			if tt.wantTie {
+				if better != worse {
+					t.Fatalf("expected equal scores, got %d and %d", better, worse)
+				}
+				return
+			}
+			if better <= worse {
+				t.Fatalf("expected %v (%d) to score higher than %v (%d)", tt.better, better, tt.worse, worse)
+			}
+		})
+	}
+}
+
+func TestSpreadTrade(t *testing.T) {
+	tests := []struct {
+		name    string
+		gpus    GPUs
+		request GPURequest
+		check   func(allocated [][]int) bool
+	}{
+		{
+			name:    "least loaded gpu",
+			gpus:    GPUs{newGPU(30, 4, 100, 12, 2), newGPU(100, 12, 100, 12, 0), newGPU(60, 6, 100, 12, 1)},
+			request: GPURequest{{Core: 20, Memory: 2}},
+			check:   func(allocated [][]int) bool { return allocated[0][0] == 1 },
+		},
+		{
+			name:    "containers of a pod are spread over gpus",
+			gpus:    GPUs{newGPU(100, 12, 100, 12, 0), newGPU(100, 12, 100, 12, 0)},
+			request: GPURequest{{Core: 30, Memory: 3}, {Core: 30, Memory: 3}},
+			check:   func(allocated [][]int) bool { return allocated[0][0] != allocated[1][0] },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			option, err := tt.gpus.Trade(&Spread{}, tt.request)
+			if err != nil {
+				t.Fatalf("trade failed: %v", err)
+			}
+			if !tt.check(option.Allocated) {
+				t.Fatalf("unexpected allocation %v on %s", option.Allocated, tt.gpus)
+			}
+		})
+	}
+}
+
+func TestSpreadNodeScore(t *testing.T) {
+	newNode := func(name string) *v1.Node {
+		node := &v1.Node{
+			Status: v1.NodeStatus{
+				Allocatable: map[v1.ResourceName]resource.Quantity{
+					v1alpha1.ResourceGPUCore:   resource.MustParse("200"),
+					v1alpha1.ResourceGPUMemory: resource.MustParse("24"),
+				},
+			},
+		}
+		node.Name = name
+		return node
+	}
+	idle, err := NewNodeAllocator(nil, newNode("idle"), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Spread{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, err := NewNodeAllocator(nil, newNode("busy"), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Spread{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy.GPUs[0].Add(GPUUnit{Core: 80, Memory: 8})
+	busy.GPUs[1].Add(GPUUnit{Core: 50, Memory: 6})
+
+	pod := generatePods("spread", 1)[0]
+	idleScore, busyScore := idle.Score(&pod), busy.Score(&pod)
+	if idleScore <= busyScore {
+		t.Fatalf("expected idle node score %d to be higher than busy node score %d", idleScore, busyScore)
+	}
+}
+
+func TestPolicyConfigBuildRater(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+		check   func(r Rater) bool
+	}{
+		{
+			name:   "single rater is used as-is",
+			config: "raters:\n- name: spread\n",
+			check: func(r Rater) bool {
+				_, ok := r.(*Spread)
+				return ok
+			},
+		},
+		{
+			name:   "weights default to one",
+			config: "raters:\n- name: binpack\n  weight: 3\n- name: fragmentation\n",
+			check: func(r Rater) bool {
+				c, ok := r.(*CompositeRater)
+				return ok && len(c.Raters) == 2 && c.Raters[0].Weight == 3 && c.Raters[1].Weight == 1
+			},
+		},
+		{
+			name:    "unknown rater",
+			config:  "raters:\n- name: random\n",
+			wantErr: true,
+		},
+		{
+			name:    "negative weight",
+			config:  "raters:\n- name: spread\n  weight: -1\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty policy",
+			config:  "raters: []\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.yaml")
+			if err := ioutil.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			config, err := LoadPolicyConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rater, err := config.BuildRater()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got rater %+v", rater)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(rater) {
+				t.Fatalf("unexpected rater %+v", rater)
+			}
+		})
+	}
+}
+
+type constRater int
+
+func (c constRater) Rate(g GPUs, indexes []int) int {
+	return int(c)
+}
+
+func TestCompositeRate(t *testing.T) {
+	rater, err := NewCompositeRater(
+		WeightedRater{Name: "a", Rater: constRater(100), Weight: 3},
+		WeightedRater{Name: "b", Rater: constRater(20), Weight: 1},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score := rater.Rate(nil, nil); score != 80 {
+		t.Fatalf("expected weighted score 80, got %d", score)
+	}
+}
+
+func TestFragmentationRate(t *testing.T) {
+	gpus := GPUs{newGPU(50, 6, 100, 12, 1), newGPU(100, 12, 100, 12, 0)}
+	rater := &Fragmentation{}
+
+	gpus[0].Add(GPUUnit{Core: 20, Memory: 2})
+	packed := rater.Rate(gpus, []int{0})
+	gpus[0].Sub(GPUUnit{Core: 20, Memory: 2})
+
+	gpus[1].Add(GPUUnit{Core: 20, Memory: 2})
+	scattered := rater.Rate(gpus, []int{1})
+	gpus[1].Sub(GPUUnit{Core: 20, Memory: 2})
+
+	if packed <= scattered {
+		t.Fatalf("expected packing on the used gpu (%d) to score higher than using the idle gpu (%d)", packed, scattered)
+	}
+}
+
+func TestPodPolicyOverride(t *testing.T) {
+	node := &v1.Node{
+		Status: v1.NodeStatus{
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1alpha1.ResourceGPUCore:   resource.MustParse("200"),
+				v1alpha1.ResourceGPUMemory: resource.MustParse("24"),
+			},
+		},
+	}
+	tests := []struct {
+		name    string
+		policy  string
+		want    int
+		wantErr bool
+	}{
+		{name: "default binpack packs onto the used gpu", want: 0},
+		{name: "spread annotation uses the idle gpu", policy: utils.PrioritySpread, want: 1},
+		{name: "unknown policy fails", policy: "random", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ni, err := NewNodeAllocator(nil, node, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ni.GPUs[0].Add(GPUUnit{Core: 50, Memory: 4})
+
+			pod := generatePods("policy", 1)[0]
+			pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUCore] = resource.MustParse("20")
+			if tt.policy != "" {
+				pod.Annotations = map[string]string{utils.AnnotationEGPUPolicy: tt.policy}
+			}
+			ids, err := ni.Assume(&pod)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", ids)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids[0][0] != tt.want {
+				t.Fatalf("expected gpu %d, got %v", tt.want, ids)
+			}
+		})
+	}
+}
+
+func TestBinpackRateNormalized(t *testing.T) {
+	gpus := GPUs{newGPU(0, 0, 100, 16384, 2), newGPU(100, 16384, 100, 16384, 0)}
+	rater := &Binpack{}
+	if score := rater.Rate(gpus, []int{0}); score != NormalizedScoreMax {
+		t.Fatalf("expected a full and a free gpu scored %d, got %d", NormalizedScoreMax, score)
+	}
+	if score := rater.Rate(gpus, []int{0, 1}); score < ScoreMin || score >= NormalizedScoreMax {
+		t.Fatalf("expected an option on two gpus scored lower, got %d", score)
+	}
+	even := GPUs{newGPU(50, 8192, 100, 16384, 1), newGPU(50, 8192, 100, 16384, 1)}
+	if score := rater.Rate(even, []int{0}); score != ScoreMin {
+		t.Fatalf("expected evenly used gpus scored %d, got %d", ScoreMin, score)
+	}
+}
+
+func TestCompositeBinpackSpread(t *testing.T) {
+	rater, err := NewCompositeRater(
+		WeightedRater{Name: utils.PriorityBinPack, Rater: &Binpack{}, Weight: 1},
+		WeightedRater{Name: utils.PrioritySpread, Rater: &Spread{}, Weight: 3},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gpu 0 is half used, binpack prefers to fill it while spread prefers the free gpu 1
+	gpus := GPUs{newGPU(50, 8192, 100, 16384, 1), newGPU(100, 16384, 100, 16384, 0)}
+	option, err := gpus.Trade(rater, GPURequest{{Core: 20, Memory: 2048}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if option.Allocated[0][0] != 1 {
+		t.Fatalf("expected the composite weighted to spread to take gpu 1, got %v", option.Allocated)
+	}
+	option, err = gpus.Trade(&Binpack{}, GPURequest{{Core: 20, Memory: 2048}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if option.Allocated[0][0] != 0 {
+		t.Fatalf("expected binpack alone to take gpu 0, got %v", option.Allocated)
+	}
+}
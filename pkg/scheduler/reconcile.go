@@ -0,0 +1,250 @@
+package scheduler
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	log "k8s.io/klog/v2"
+)
+
+// Reasons of the discrepancies between the allocation of a node and the pods bound to it, they are
+// the reasons of the events recorded for them.
+const (
+	// ReasonPodMissing is a pod bound to the node with GPUs which the allocation doesn't account.
+	ReasonPodMissing = "GPUPodMissing"
+	// ReasonPodStale is a pod the allocation accounts which is no longer bound to the node.
+	ReasonPodStale = "GPUPodStale"
+	// ReasonPodChanged is a pod accounted on other GPUs than the ones in its annotations.
+	ReasonPodChanged = "GPUPodChanged"
+	// ReasonGPUUsage is a GPU whose usage differs from the usage of the pods bound and assumed on it.
+	ReasonGPUUsage = "GPUUsageDrift"
+)
+
+// Discrepancy is a difference between the allocation of a node and the pods bound to it in the
+// cluster.
+type Discrepancy struct {
+	Reason string `json:"reason"`
+	Node   string `json:"node"`
+	// Pod is the namespace/name of the pod, if the discrepancy is about a pod.
+	Pod     string    `json:"pod,omitempty"`
+	PodUID  types.UID `json:"podUID,omitempty"`
+	Message string    `json:"message"`
+	// Repaired is set if the allocation of the node was rebuilt for the discrepancy.
+	Repaired bool `json:"repaired"`
+}
+
+// key identifies the discrepancy across reconciles.
+func (d Discrepancy) key() string {
+	return d.Node + "/" + d.Reason + "/" + string(d.PodUID)
+}
+
+// Reconcile compares the allocation of each known node with the pods bound to it in the cluster and
+// returns the discrepancies found. A discrepancy may be transient, as pods are listed before their
+// events are handled, so a node is repaired only if a discrepancy of it was found by the previous
+// reconcile too. A repaired node is rebuilt from the pods bound to it, the pods assumed on it are
+// given their GPUs again when they are bound.
+func (d *GPUUnitScheduler) Reconcile() []Discrepancy {
+	d.lock.Lock()
+	names := make([]string, 0, len(d.nodeMaps))
+	for name := range d.nodeMaps {
+		names = append(names, name)
+	}
+	d.lock.Unlock()
+	sort.Strings(names)
+
+	found := make([]Discrepancy, 0)
+	drifts := make(map[string]struct{})
+	for _, name := range names {
+		found = append(found, d.reconcileNode(name, drifts)...)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.drifts = drifts
+	return found
+}
+
+// reconcileNode compares the allocation of the node with the pods bound to it, and records the
+// discrepancies not repaired in drifts. Pods are listed and compared under the lock of the node, the
+// lock of the scheduler is held only to look the node up and to replace it.
+func (d *GPUUnitScheduler) reconcileNode(name string, drifts map[string]struct{}) []Discrepancy {
+	d.lock.Lock()
+	ni, ok := d.nodeMaps[name]
+	previous := d.drifts
+	binding := make([]types.UID, 0, len(d.bindingPods))
+	for uid := range d.bindingPods {
+		binding = append(binding, uid)
+	}
+	d.lock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	ni.lock.Lock()
+	defer ni.lock.Unlock()
+	// the node may have been replaced or dropped since it was looked up
+	if ni.closed {
+		return nil
+	}
+	found, pods, confirmed := d.nodeDrift(ni, binding, previous)
+	if !confirmed {
+		for _, f := range found {
+			drifts[f.key()] = struct{}{}
+		}
+		return found
+	}
+
+	log.Warningf("Allocation of node %s drifted from the pods bound to it, rebuild it", name)
+	ni.Close()
+	na, err := newNodeAllocator(pods, ni.Node, d.coreName, d.memName, d.rater, d.Ledger)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err != nil {
+		log.Errorf("Failed to rebuild the allocation of node %s: %v", name, err)
+		delete(d.nodeMaps, name)
+		return found
+	}
+	d.nodeMaps[name] = na
+	for i := range found {
+		found[i].Repaired = true
+		if found[i].Reason == ReasonPodStale {
+			delete(d.podMaps, found[i].PodUID)
+			d.releasedPodMap[found[i].PodUID] = struct{}{}
+		}
+	}
+	for uid, pod := range na.accountedPods() {
+		d.podMaps[uid] = pod
+	}
+	return found
+}
+
+// nodeDrift lists the pods bound to the node and returns the discrepancies of its allocation from
+// them, with the pods. It's confirmed if a discrepancy was found by the previous reconcile too. The
+// lock of the node must be held.
+func (d *GPUUnitScheduler) nodeDrift(ni *NodeAllocator, binding []types.UID, previous map[string]struct{}) ([]Discrepancy, []v1.Pod, bool) {
+	// a pod being bound is not listed as bound yet
+	for _, uid := range binding {
+		if _, ok := ni.podsMap[uid]; ok {
+			return nil, nil, false
+		}
+	}
+
+	name := ni.Node.Name
+	listed, err := d.Lister.AssumedPods(name)
+	if err != nil {
+		log.Errorf("Failed to list pods of node %s: %v", name, err)
+		return nil, nil, false
+	}
+	pods := make([]v1.Pod, 0, len(listed))
+	for i := range listed {
+		if !IsCompletedPod(&listed[i]) {
+			pods = append(pods, listed[i])
+		}
+	}
+	sortPods(pods)
+	expected, err := newNodeAllocator(pods, ni.Node, d.coreName, d.memName, d.rater, nil)
+	if err != nil {
+		log.Errorf("Failed to build the allocation of node %s: %v", name, err)
+		return nil, nil, false
+	}
+	found := ni.diff(expected)
+
+	for _, f := range found {
+		if _, ok := previous[f.key()]; ok {
+			return found, pods, true
+		}
+	}
+	return found, pods, false
+}
+
+// diff returns the discrepancies of the allocation from the expected one, built from the pods bound
+// to the node. The usage of GPUs is compared only if the pods agree.
+func (ni *NodeAllocator) diff(expected *NodeAllocator) []Discrepancy {
+	found := make([]Discrepancy, 0)
+	newDiscrepancy := func(reason string, pod *v1.Pod, format string, args ...interface{}) Discrepancy {
+		return Discrepancy{
+			Reason:  reason,
+			Node:    ni.Node.Name,
+			Pod:     pod.Namespace + "/" + pod.Name,
+			PodUID:  pod.UID,
+			Message: fmt.Sprintf(format, args...),
+		}
+	}
+
+	actual, bound := ni.accountedPods(), expected.accountedPods()
+	for uid, pod := range bound {
+		known, ok := actual[uid]
+		if !ok {
+			found = append(found, newDiscrepancy(ReasonPodMissing, pod, "pod %s/%s is bound to gpus %v of node %s but not accounted",
+				pod.Namespace, pod.Name, ni.podDevices(pod), ni.Node.Name))
+			continue
+		}
+		if devices, want := ni.podDevices(known), ni.podDevices(pod); !reflect.DeepEqual(devices, want) {
+			found = append(found, newDiscrepancy(ReasonPodChanged, pod, "pod %s/%s is accounted on gpus %v of node %s but bound to gpus %v",
+				pod.Namespace, pod.Name, devices, ni.Node.Name, want))
+		}
+	}
+	for uid, pod := range actual {
+		if _, ok := bound[uid]; !ok {
+			found = append(found, newDiscrepancy(ReasonPodStale, pod, "pod %s/%s is accounted on gpus %v of node %s but no longer bound to it",
+				pod.Namespace, pod.Name, ni.podDevices(pod), ni.Node.Name))
+		}
+	}
+	if len(found) > 0 {
+		sort.Slice(found, func(i, j int) bool { return found[i].Pod < found[j].Pod })
+		return found
+	}
+
+	for _, a := range ni.assumed {
+		expected.GPUs.Transact(a.option)
+	}
+	for i, gpu := range ni.GPUs {
+		want := expected.GPUs[i]
+		if gpu.CoreAvailable != want.CoreAvailable || gpu.MemoryAvailable != want.MemoryAvailable {
+			found = append(found, Discrepancy{
+				Reason: ReasonGPUUsage,
+				Node:   ni.Node.Name,
+				Message: fmt.Sprintf("gpu %d of node %s has core %d and memory %d available, expected core %d and memory %d",
+					gpu.Index, ni.Node.Name, gpu.CoreAvailable, gpu.MemoryAvailable, want.CoreAvailable, want.MemoryAvailable),
+			})
+		}
+	}
+	return found
+}
+
+// accountedPods returns the pods bound to the node which take its GPUs, overcommitted ones included,
+// by uid. Pods of other modes bound to the node take none.
+func (ni *NodeAllocator) accountedPods() map[types.UID]*v1.Pod {
+	pods := make(map[types.UID]*v1.Pod, len(ni.podsMap)+len(ni.overcommitted))
+	for uid, pod := range ni.podsMap {
+		if option, err := ni.optionFromPod(pod); err != nil || !optionEmpty(ni.GPUs.footprint(option)) {
+			pods[uid] = pod
+		}
+	}
+	for uid, pod := range ni.overcommitted {
+		pods[uid] = pod
+	}
+	return pods
+}
+
+// podDevices returns the devices of each container of the pod in its annotations.
+func (ni *NodeAllocator) podDevices(pod *v1.Pod) GPUIDs {
+	option, err := NewGPUOptionFromPod(pod, ni.CoreName, ni.MemName)
+	if err != nil {
+		return nil
+	}
+	return option.Allocated
+}
+
+func optionEmpty(footprint []gpuUsage) bool {
+	for _, usage := range footprint {
+		if !usage.empty() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package scheduler
+
+import (
+	"testing"
+
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// reasons returns the reasons of the discrepancies, and whether all of them were repaired.
+func reasons(found []Discrepancy) ([]string, bool) {
+	names := make([]string, len(found))
+	repaired := len(found) > 0
+	for i, f := range found {
+		names[i] = f.Reason
+		repaired = repaired && f.Repaired
+	}
+	return names, repaired
+}
+
+func TestReconcile(t *testing.T) {
+	node := newTopologyNode(2, "")
+	share := newModePod("share", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+	bound := GetUpdatedPodAnnotationSpec(&share, GPUIDs{{0}})
+	bound.Spec.NodeName = node.Name
+	lister := newTestClusterLister(t, []*v1.Node{node}, []*v1.Pod{bound})
+	config := ElasticSchedulerConfig{Lister: lister, Rater: &Binpack{}, Ledger: NewDeviceLedger()}
+	d := &GPUUnitScheduler{BaseScheduler: newBaseScheduler(config, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)}
+	if _, err := d.lockNode(node.Name); err != nil {
+		t.Fatal(err)
+	}
+	d.nodeMaps[node.Name].lock.Unlock()
+	// assumed pods are not listed as bound
+	pending := newModePod("pending", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "20", "4")
+	if _, _, err := d.Assume([]string{node.Name}, &pending); err != nil {
+		t.Fatal(err)
+	}
+	if found := d.Reconcile(); len(found) != 0 {
+		t.Fatalf("expected no discrepancy, got %+v", found)
+	}
+
+	// the event of the pod was missed
+	d.nodeMaps[node.Name].Forget(bound)
+	if got, repaired := reasons(d.Reconcile()); len(got) != 1 || got[0] != ReasonPodMissing || repaired {
+		t.Fatalf("expected the pod found missing, got %v, %v", got, repaired)
+	}
+	if got, repaired := reasons(d.Reconcile()); len(got) != 1 || got[0] != ReasonPodMissing || !repaired {
+		t.Fatalf("expected the pod repaired, got %v, %v", got, repaired)
+	}
+	ni := d.nodeMaps[node.Name]
+	if ni.GPUs[0].CoreAvailable != 40 || ni.GPUs[1].CoreAvailable != 100 || ni.Assumed() != 0 || !d.KnownPod(bound) {
+		t.Fatalf("expected the allocation rebuilt from the bound pod, got %s", ni.GPUs)
+	}
+
+	// the pod was deleted, and another one bound, before the next reconcile
+	stale := GetUpdatedPodAnnotationSpec(&share, GPUIDs{{0}})
+	stale.Name, stale.UID, stale.Spec.NodeName = "stale", "stale", node.Name
+	if err := d.AddPod(stale); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reasons(d.Reconcile()); len(got) != 1 || got[0] != ReasonPodStale {
+		t.Fatalf("expected the pod found stale, got %v", got)
+	}
+	if err := d.ForgetPod(stale); err != nil {
+		t.Fatal(err)
+	}
+	if found := d.Reconcile(); len(found) != 0 {
+		t.Fatalf("expected the transient discrepancy gone, got %+v", found)
+	}
+
+	// the pod was deleted and its event missed
+	if err := d.AddPod(stale); err != nil {
+		t.Fatal(err)
+	}
+	d.Reconcile()
+	if got, repaired := reasons(d.Reconcile()); len(got) != 1 || got[0] != ReasonPodStale || !repaired {
+		t.Fatalf("expected the stale pod repaired, got %v, %v", got, repaired)
+	}
+	ni = d.nodeMaps[node.Name]
+	if ni.GPUs[0].CoreAvailable != 40 || d.KnownPod(stale) || !d.ReleasedPod(stale) {
+		t.Fatalf("expected the stale pod dropped, got %s", ni.GPUs)
+	}
+
+	// the annotations of the pod were edited
+	moved := GetUpdatedPodAnnotationSpec(&share, GPUIDs{{1}})
+	moved.Spec.NodeName = node.Name
+	if err := lister.pods.Update(moved); err != nil {
+		t.Fatal(err)
+	}
+	d.Reconcile()
+	if got, repaired := reasons(d.Reconcile()); len(got) != 1 || got[0] != ReasonPodChanged || !repaired {
+		t.Fatalf("expected the changed pod repaired, got %v, %v", got, repaired)
+	}
+	ni = d.nodeMaps[node.Name]
+	if ni.GPUs[0].CoreAvailable != 100 || ni.GPUs[1].CoreAvailable != 40 {
+		t.Fatalf("expected the pod moved to gpu 1, got %s", ni.GPUs)
+	}
+
+	// the usage of a gpu drifted
+	ni.GPUs[0].CoreAvailable -= 10
+	d.Reconcile()
+	if got, repaired := reasons(d.Reconcile()); len(got) != 1 || got[0] != ReasonGPUUsage || !repaired {
+		t.Fatalf("expected the gpu usage repaired, got %v, %v", got, repaired)
+	}
+	if ni := d.nodeMaps[node.Name]; ni.GPUs[0].CoreAvailable != 100 {
+		t.Fatalf("expected the gpu usage repaired, got %s", ni.GPUs)
+	}
+}
@@ -4,15 +4,19 @@ import (
 	"context"
 	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
 	"elasticgpu.io/elastic-gpu/client/clientset/versioned"
-	"encoding/json"
 	"fmt"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
-	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/leader"
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/metrics"
 	schetypes "elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
 
 	v1 "k8s.io/api/core/v1"
@@ -21,12 +25,32 @@ import (
 )
 
 type ElasticSchedulerConfig struct {
-	Clientset            *kubernetes.Clientset
-	EGPUClientset        *versioned.Clientset
+	Clientset     kubernetes.Interface
+	EGPUClientset *versioned.Clientset
+	// Lister serves the nodes and their pods the node allocators are built from.
+	Lister               *ClusterLister
 	RegisteredSchedulers map[v1.ResourceName]ResourceScheduler
 	Rater                Rater
+	// Ledger is shared by the schedulers of all modes, see DeviceLedger.
+	Ledger *DeviceLedger
+	// AssumeTTL is how long a pod stays assumed on a node without being filtered again or bound, 0 to
+	// keep it until the pod is bound or deleted.
+	AssumeTTL time.Duration
+	// Workers is the number of nodes filtered at the same time for a pod, DefaultWorkers if not set.
+	Workers int
+	// Leader elects the replica which serves pods, nil if the replica is the only one.
+	Leader *leader.Elector
+	// Recorder records the outcomes of scheduling pods as events on the pods, nil to record none.
+	Recorder record.EventRecorder
 }
 
+// DefaultWorkers is the number of nodes filtered at the same time for a pod by default.
+const DefaultWorkers = 4
+
+// DefaultAssumeTTL leaves kube-scheduler enough time to bind a filtered pod, while pods bound to other
+// nodes or never bound don't hold GPUs for long.
+const DefaultAssumeTTL = 5 * time.Minute
+
 type ResourceScheduler interface {
 	Assume(nodes []string, pod *v1.Pod) ([]string, map[string]string, error)
 	Score(node []string, pod *v1.Pod) []int
@@ -35,9 +59,51 @@ type ResourceScheduler interface {
 	ForgetPod(pod *v1.Pod) error
 	KnownPod(pod *v1.Pod) bool
 	ReleasedPod(pod *v1.Pod) bool
-	Status() string
+	// ExpireAssumptions gives back the options of the pods assumed before the given time and not
+	// bound since, and returns how many were given back.
+	ExpireAssumptions(before time.Time) int
+	// NodeStatuses returns the status of each known node.
+	NodeStatuses() map[string]NodeStatus
+	// Allocations returns the allocation of each known node, see NodeAllocation.
+	Allocations() []NodeAllocation
+	// NodeAllocation returns the allocation of the node, false if the node is not known.
+	NodeAllocation(name string) (NodeAllocation, bool)
+	// PodAllocation returns the allocation of the pod, false if the pod is neither bound nor assumed.
+	PodAllocation(namespace, name string) (PodAllocation, bool)
+	// UpdateNode rebuilds the state of the node if its GPUs changed, with the pods still bound to it.
+	UpdateNode(node *v1.Node) error
+	// RemoveNode drops the state of the node.
+	RemoveNode(name string)
+	// Reconcile compares the state of the known nodes with the pods bound to them, repairs the nodes
+	// which drifted from them, and returns the discrepancies found.
+	Reconcile() []Discrepancy
+}
+
+// NodeStatus is the state of a node beyond its GPUs.
+type NodeStatus struct {
+	// Assumed is the number of pods assumed on the node and not bound yet.
+	Assumed int `json:"assumed"`
+	// Overcommitted lists the pods bound to the node whose allocations don't fit its GPUs.
+	Overcommitted []string    `json:"overcommitted,omitempty"`
+	GPUs          []GPUStatus `json:"gpus"`
+}
+
+// GPUStatus is the usage of a GPU of a node.
+type GPUStatus struct {
+	Index           int `json:"index"`
+	CoreTotal       int `json:"coreTotal"`
+	CoreAvailable   int `json:"coreAvailable"`
+	MemoryTotal     int `json:"memoryTotal"`
+	MemoryAvailable int `json:"memoryAvailable"`
+	// Tenants is the number of pods bound or assumed on the GPU.
+	Tenants int `json:"tenants"`
 }
 
+// BaseScheduler keeps the allocators of the nodes. Its lock guards its maps, while each allocator is
+// guarded by its own lock, so that pods on different nodes are handled at the same time. The lock of
+// the scheduler may be taken with the lock of an allocator held, never the other way round, so that a
+// node busy with a pod holds up no other node. An allocator is closed and replaced with its lock held,
+// so that it's never found closed in the maps.
 type BaseScheduler struct {
 	ElasticSchedulerConfig
 	rater          Rater
@@ -47,6 +113,13 @@ type BaseScheduler struct {
 	podMaps        map[types.UID]*v1.Pod
 	nodeMaps       map[string]*NodeAllocator
 	releasedPodMap map[types.UID]struct{}
+	// bindingPods holds the pods being bound.
+	bindingPods map[types.UID]struct{}
+	// drifts holds the keys of the discrepancies found by the last reconcile and not repaired.
+	drifts map[string]struct{}
+	// assumedOn holds the nodes each pod is assumed on, so that its options are given back without
+	// visiting the other nodes.
+	assumedOn map[types.UID][]string
 }
 
 func newBaseScheduler(config ElasticSchedulerConfig, coreName v1.ResourceName, memName v1.ResourceName) BaseScheduler {
@@ -56,25 +129,27 @@ func newBaseScheduler(config ElasticSchedulerConfig, coreName v1.ResourceName, m
 		memName:        memName,
 		podMaps:        make(map[types.UID]*v1.Pod),
 		nodeMaps:       make(map[string]*NodeAllocator),
-		releasedPodMap: make(map[types.UID]struct{})}
+		releasedPodMap: make(map[types.UID]struct{}),
+		bindingPods:    make(map[types.UID]struct{}),
+		drifts:         make(map[string]struct{}),
+		assumedOn:      make(map[types.UID][]string)}
 }
 
+// getNodeInfo returns the allocator of the node, built from the lister if the node is not known yet.
+// The lock of the scheduler must be held.
 func (d *BaseScheduler) getNodeInfo(name string) (*NodeAllocator, error) {
 	if na, ok := d.nodeMaps[name]; ok {
 		return na, nil
 	}
-	node, err := d.Clientset.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
+	node, err := d.Lister.GetNode(name)
 	if err != nil {
 		return nil, err
 	}
-	pods, err := d.Clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("%s=%s", schetypes.EGPUAssumed, "true"),
-		FieldSelector: fields.OneTermEqualSelector(schetypes.NodeNameField, name).String(),
-	})
+	pods, err := d.Lister.AssumedPods(name)
 	if err != nil {
 		return nil, err
 	}
-	na, err := NewNodeAllocator(pods.Items, node, d.coreName, d.memName, d.rater)
+	na, err := newNodeAllocator(pods, node, d.coreName, d.memName, d.rater, d.Ledger)
 	if err != nil {
 		return nil, err
 	}
@@ -83,22 +158,65 @@ func (d *BaseScheduler) getNodeInfo(name string) (*NodeAllocator, error) {
 	return na, nil
 }
 
+// lockNode returns the allocator of the node with its lock held, built if the node is not known yet.
+// Allocators are replaced when their nodes change, a replaced allocator is never returned.
+func (d *BaseScheduler) lockNode(name string) (*NodeAllocator, error) {
+	for {
+		d.lock.Lock()
+		ni, err := d.getNodeInfo(name)
+		d.lock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		ni.lock.Lock()
+		if !ni.closed {
+			return ni, nil
+		}
+		ni.lock.Unlock()
+	}
+}
+
+// lockKnownNode is lockNode for known nodes only, it returns nil if the node is not known.
+func (d *BaseScheduler) lockKnownNode(name string) *NodeAllocator {
+	for {
+		d.lock.Lock()
+		ni := d.nodeMaps[name]
+		d.lock.Unlock()
+		if ni == nil {
+			return nil
+		}
+		ni.lock.Lock()
+		if !ni.closed {
+			return ni
+		}
+		ni.lock.Unlock()
+	}
+}
+
+// eachNode calls f with the allocator of each known node, its lock held.
+func (d *BaseScheduler) eachNode(f func(ni *NodeAllocator)) {
+	d.lock.Lock()
+	names := make([]string, 0, len(d.nodeMaps))
+	for name := range d.nodeMaps {
+		names = append(names, name)
+	}
+	d.lock.Unlock()
+
+	for _, name := range names {
+		if ni := d.lockKnownNode(name); ni != nil {
+			f(ni)
+			ni.lock.Unlock()
+		}
+	}
+}
+
 func NewGPUUnitScheduler(config ElasticSchedulerConfig, coreName v1.ResourceName, memName v1.ResourceName) (ResourceScheduler, error) {
 	di := &GPUUnitScheduler{
 		BaseScheduler: newBaseScheduler(config, coreName, memName),
 	}
-	pods, err := di.Clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("%s=%s", schetypes.EGPUAssumed, "true"),
-	})
-	if err != nil {
-		return nil, err
-	}
-	for _, pod := range pods.Items {
-		if pod.Spec.NodeName == "" {
-			continue
-		}
-		if _, err := di.getNodeInfo(pod.Spec.NodeName); err != nil {
-			log.Errorf("Failed to get node %s: %s", pod.Spec.NodeName, err.Error())
+	for _, name := range di.Lister.AssumedNodes() {
+		if _, err := di.getNodeInfo(name); err != nil {
+			log.Errorf("Failed to get node %s: %s", name, err.Error())
 			continue
 		}
 	}
@@ -110,162 +228,265 @@ type GPUUnitScheduler struct {
 }
 
 func (d *GPUUnitScheduler) Assume(nodes []string, pod *v1.Pod) ([]string, map[string]string, error) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
-
+	defer metrics.ObserveOperation(string(d.coreName), metrics.OperationFilter, time.Now())
 	res := make([]error, len(nodes))
 	ans := make([]bool, len(nodes))
-	nodeInfos := make([]*NodeAllocator, len(nodes))
-	for i, name := range nodes {
-		ni, err := d.getNodeInfo(name)
-		if err != nil {
-			ni = nil
-			ans[i] = false
-			res[i] = fmt.Errorf("elastic gpu scheduler get node failed: %v", err)
-		}
-		nodeInfos[i] = ni
-	}
 
-	ch := make(chan int, len(nodeInfos))
-	wg := sync.WaitGroup{}
-	for i := 0; i < len(nodeInfos); i++ {
+	ch := make(chan int, len(nodes))
+	for i := 0; i < len(nodes); i++ {
 		ch <- i
 	}
+	close(ch)
 
-	for i := 0; i < 4; i++ {
+	wg := sync.WaitGroup{}
+	for i := 0; i < d.workers(len(nodes)); i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for {
-				select {
-				case number := <-ch:
-					if nodeInfos[number] == nil {
-						continue
-					}
-					ids, err := nodeInfos[number].Assume(pod)
-					klog.V(5).Infof("Assume pod %s/%s on node %s, GPU index: %+v, err: %v", pod.Namespace, pod.Name, nodes[number], ids, err)
-					ans[number] = ids != nil
-					res[number] = err
-				default:
-					return
+			for number := range ch {
+				ni, err := d.lockNode(nodes[number])
+				if err != nil {
+					ans[number] = false
+					res[number] = withReason(reasonNodeUnavailable, fmt.Errorf("elastic gpu scheduler get node failed: %v", err))
+					continue
 				}
+				ids, err := ni.Assume(pod)
+				ni.lock.Unlock()
+				klog.V(5).Infof("Assume pod %s/%s on node %s, GPU index: %+v, err: %v", pod.Namespace, pod.Name, nodes[number], ids, err)
+				ans[number] = ids != nil
+				res[number] = err
 			}
-
 		}()
 	}
 	wg.Wait()
+	d.unassume(pod, nodes...)
 
 	filterdNodes := []string{}
 	failedNodes := map[string]string{}
+	failures := []error{}
 	for i := 0; i < len(ans); i++ {
 		if ans[i] {
 			filterdNodes = append(filterdNodes, nodes[i])
 		} else {
 			failedNodes[nodes[i]] = res[i].Error()
+			failures = append(failures, res[i])
+			metrics.Fail(string(d.coreName), metrics.OperationFilter, failureReason(res[i]))
 		}
 	}
+	d.lock.Lock()
+	d.assumedOn[pod.UID] = filterdNodes
+	d.lock.Unlock()
+	if len(nodes) > 0 && len(filterdNodes) == 0 {
+		d.recordEvent(pod, v1.EventTypeWarning, ReasonFilteredOut, "%s", filterSummary(len(nodes), failures))
+	}
 	return filterdNodes, failedNodes, nil
 }
 
+// workers returns the number of goroutines filtering the nodes of a pod.
+func (d *GPUUnitScheduler) workers(nodes int) int {
+	workers := d.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if workers > nodes {
+		workers = nodes
+	}
+	return workers
+}
+
 func (d *GPUUnitScheduler) Score(nodes []string, pod *v1.Pod) []int {
-	d.lock.Lock()
-	defer d.lock.Unlock()
+	defer metrics.ObserveOperation(string(d.coreName), metrics.OperationScore, time.Now())
 	scores := make([]int, len(nodes))
 	for i := 0; i < len(nodes); i++ {
-		ni, err := d.getNodeInfo(nodes[i])
+		ni, err := d.lockNode(nodes[i])
 		if err != nil {
 			log.Errorf("Fail to score pod %s/%s because not found target node %s: %s", pod.Namespace, pod.Name, nodes[i], err.Error())
+			metrics.Fail(string(d.coreName), metrics.OperationScore, reasonNodeUnavailable)
 			scores[i] = ScoreMin
 			continue
 		}
-		scores[i] = ni.Score(pod)
+		scores[i] = extenderScore(ni.Score(pod))
+		ni.lock.Unlock()
 	}
 	return scores
 }
 
+// Bind allocates the option the pod is assumed with and binds the pod to the node, the allocation is
+// rolled back if the pod can't be bound. Binding a pod again to its node does nothing.
 func (d *GPUUnitScheduler) Bind(node string, pod *v1.Pod) (err error) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
+	defer func(start time.Time) {
+		metrics.ObserveOperation(string(d.coreName), metrics.OperationBind, start)
+		if err != nil {
+			metrics.Fail(string(d.coreName), metrics.OperationBind, failureReason(err))
+		}
+	}(time.Now())
+	if bound, err := d.startBind(node, pod); bound || err != nil {
+		return err
+	}
+	defer d.endBind(pod)
 
-	ni, err := d.getNodeInfo(node)
+	ni, err := d.lockNode(node)
 	if err != nil {
-		return err
+		return withReason(reasonNodeUnavailable, err)
 	}
+	score := extenderScore(ni.Score(pod))
 	ids, err := ni.Allocate(pod)
+	ni.lock.Unlock()
 	if err != nil {
 		return err
 	}
+	d.unassume(pod, node)
 
 	newPod := GetUpdatedPodAnnotationSpec(pod, ids)
-	if _, err := d.Clientset.CoreV1().Pods(newPod.Namespace).Update(context.Background(), newPod, metav1.UpdateOptions{}); err != nil {
-		if err.Error() == schetypes.OptimisticLockErrorMsg {
-			pod, err = d.Clientset.CoreV1().Pods(pod.Namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
-			if err != nil {
-				return err
-			}
-			newPod = GetUpdatedPodAnnotationSpec(pod, ids)
-			if _, err = d.Clientset.CoreV1().Pods(pod.Namespace).Update(context.Background(), newPod, metav1.UpdateOptions{}); err != nil {
-				return err
-			}
-		} else {
-			return nil
+	newPod.Spec.NodeName = node
+	if err := d.bindPod(node, pod, ids); err != nil {
+		klog.Errorf("Failed to bind pod %s/%s to node %s, roll back its allocation: %v", pod.Namespace, pod.Name, node, err)
+		if ni := d.lockKnownNode(node); ni != nil {
+			ni.Forget(newPod)
+			ni.lock.Unlock()
 		}
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	klog.V(5).Infof("update pod %s to pods cache %+v", newPod.Name, d.podMaps)
+	d.podMaps[pod.UID] = newPod
+	d.recordEvent(pod, v1.EventTypeNormal, ReasonAssigned, "Assigned GPUs %v of node %s, score %d", ids, node, score)
+	return nil
+}
+
+// startBind marks the pod as being bound, it returns true if the pod is already bound to the node,
+// and fails if it's bound to another node or being bound.
+func (d *GPUUnitScheduler) startBind(node string, pod *v1.Pod) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	boundNode := pod.Spec.NodeName
+	if known, ok := d.podMaps[pod.UID]; ok && known.Spec.NodeName != "" {
+		boundNode = known.Spec.NodeName
+	}
+	if boundNode == node {
+		return true, nil
+	}
+	if boundNode != "" {
+		return false, withReason(reasonAlreadyBound, fmt.Errorf("pod %s/%s is already bound to node %s", pod.Namespace, pod.Name, boundNode))
+	}
+	if _, ok := d.bindingPods[pod.UID]; ok {
+		return false, withReason(reasonBindInProgress, fmt.Errorf("pod %s/%s is being bound", pod.Namespace, pod.Name))
+	}
+	d.bindingPods[pod.UID] = struct{}{}
+	return false, nil
+}
+
+func (d *GPUUnitScheduler) endBind(pod *v1.Pod) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.bindingPods, pod.UID)
+}
+
+// bindPod sets the GPUs of the pod in its annotations and binds it to the node. The annotations are
+// set first, so that they are read once the pod starts on the node, and removed if it can't be bound.
+func (d *GPUUnitScheduler) bindPod(node string, pod *v1.Pod, ids GPUIDs) error {
+	patch, err := GetPodAnnotationPatch(pod, ids)
+	if err != nil {
+		return withReason(reasonPatchFailed, err)
 	}
-	if err := d.Clientset.CoreV1().Pods(newPod.Namespace).Bind(context.Background(), &v1.Binding{
-		ObjectMeta: metav1.ObjectMeta{Namespace: newPod.Namespace, Name: newPod.Name, UID: newPod.UID},
+	if _, err := d.Clientset.CoreV1().Pods(pod.Namespace).Patch(context.Background(), pod.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return withReason(reasonPatchFailed, fmt.Errorf("failed to set gpus of pod %s/%s: %v", pod.Namespace, pod.Name, err))
+	}
+	if err := d.Clientset.CoreV1().Pods(pod.Namespace).Bind(context.Background(), &v1.Binding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID},
 		Target: v1.ObjectReference{
 			Kind: "Node",
 			Name: node,
 		},
 	}, metav1.CreateOptions{}); err != nil {
-		return err
+		if rollback, err := GetPodAnnotationRollbackPatch(pod, ids); err != nil {
+			klog.Errorf("Failed to unset gpus of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		} else if _, err := d.Clientset.CoreV1().Pods(pod.Namespace).Patch(context.Background(), pod.Name, types.MergePatchType, rollback, metav1.PatchOptions{}); err != nil {
+			klog.Errorf("Failed to unset gpus of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		return withReason(reasonBindingFailed, fmt.Errorf("failed to bind pod %s/%s to node %s: %v", pod.Namespace, pod.Name, node, err))
 	}
-	klog.V(5).Infof("update pod %s to pods cache %+v", newPod.Name, d.podMaps)
-	d.podMaps[pod.UID] = newPod
-
 	return nil
 }
 
 func (d *GPUUnitScheduler) AddPod(pod *v1.Pod) error {
-	d.lock.Lock()
-	defer d.lock.Unlock()
 	if pod.Spec.NodeName == "" {
 		return fmt.Errorf("pod %s/%s nodename is empty", pod.Namespace, pod.Name)
 	}
-	ni, err := d.getNodeInfo(pod.Spec.NodeName)
+	if d.KnownPod(pod) {
+		return nil
+	}
+	ni, err := d.lockNode(pod.Spec.NodeName)
 	if err != nil {
 		return err
 	}
-	if _, ok := d.podMaps[pod.UID]; ok {
-		return nil
-	}
 	ni.Add(pod, nil)
+	ni.lock.Unlock()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
 	d.podMaps[pod.UID] = pod
 	return nil
 }
 
 func (d *GPUUnitScheduler) ForgetPod(pod *v1.Pod) error {
-	d.lock.Lock()
-	defer d.lock.Unlock()
-
 	klog.V(5).Infof("Forget pod %s/%s on node %v", pod.Namespace, pod.Name, pod.Spec.NodeName)
-	if pod.Spec.NodeName != "" {
-		ni, err := d.getNodeInfo(pod.Spec.NodeName)
-		if err != nil {
-			return err
+	d.unassume(pod)
+	var ids GPUIDs
+	// nothing of the pod is held on nodes not known
+	if ni := d.lockKnownNode(pod.Spec.NodeName); ni != nil {
+		if known, ok := ni.podsMap[pod.UID]; ok {
+			ids = ni.podDevices(known)
 		}
-		if err := ni.Forget(pod); err != nil {
+		err := ni.Forget(pod)
+		ni.lock.Unlock()
+		if err != nil {
 			return err
 		}
 	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
 	if _, ok := d.podMaps[pod.UID]; ok {
 		delete(d.podMaps, pod.UID)
 		d.releasedPodMap[pod.UID] = struct{}{}
+		d.recordEvent(pod, v1.EventTypeNormal, ReasonReleased, "Released GPUs %v of node %s", ids, pod.Spec.NodeName)
 	}
 
 	return nil
 }
 
+// unassume gives back the options the pod is assumed with on the nodes except the given ones. Pods
+// are assumed on all the nodes they are filtered with, the options on the other nodes are given back
+// once the pod is filtered again or bound.
+func (d *GPUUnitScheduler) unassume(pod *v1.Pod, except ...string) {
+	d.lock.Lock()
+	var kept, given []string
+	for _, name := range d.assumedOn[pod.UID] {
+		if containsString(except, name) {
+			kept = append(kept, name)
+		} else {
+			given = append(given, name)
+		}
+	}
+	if len(kept) > 0 {
+		d.assumedOn[pod.UID] = kept
+	} else {
+		delete(d.assumedOn, pod.UID)
+	}
+	d.lock.Unlock()
+
+	for _, name := range given {
+		if ni := d.lockKnownNode(name); ni != nil {
+			ni.Unassume(pod)
+			ni.lock.Unlock()
+		}
+	}
+}
+
 func (d *GPUUnitScheduler) KnownPod(pod *v1.Pod) bool {
 	d.lock.Lock()
 	defer d.lock.Unlock()
@@ -280,55 +501,156 @@ func (d *GPUUnitScheduler) ReleasedPod(pod *v1.Pod) bool {
 	return ok
 }
 
-func (d *GPUUnitScheduler) Status() string {
-	gpus := make(map[string]GPUs)
-	for k, v := range d.nodeMaps {
-		gpus[k] = v.GPUs
+func (d *GPUUnitScheduler) ExpireAssumptions(before time.Time) int {
+	expired := 0
+	d.eachNode(func(ni *NodeAllocator) {
+		expired += ni.Expire(before)
+	})
+	return expired
+}
+
+func (d *GPUUnitScheduler) NodeStatuses() map[string]NodeStatus {
+	statuses := make(map[string]NodeStatus)
+	d.eachNode(func(ni *NodeAllocator) {
+		statuses[ni.Node.Name] = ni.Status()
+	})
+	return statuses
+}
+
+func (d *GPUUnitScheduler) UpdateNode(node *v1.Node) error {
+	ni := d.lockKnownNode(node.Name)
+	if ni == nil {
+		return nil
+	}
+	defer ni.lock.Unlock()
+	if !nodeGPUsChanged(ni.Node, node, d.coreName, d.memName) {
+		ni.Node = node
+		return nil
 	}
-	result, _ := json.Marshal(gpus)
-	return string(result)
+	klog.Infof("GPUs of node %s changed, rebuild its allocation", node.Name)
+	ni.Close()
+	na, err := newNodeAllocator(ni.Pods(), node, d.coreName, d.memName, d.rater, d.Ledger)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err != nil {
+		delete(d.nodeMaps, node.Name)
+		return err
+	}
+	if overcommitted := na.Overcommitted(); len(overcommitted) > 0 {
+		klog.Warningf("Allocations of pods %v exceed the gpus of node %s", overcommitted, node.Name)
+	}
+	d.nodeMaps[node.Name] = na
+	return nil
+}
+
+func (d *GPUUnitScheduler) RemoveNode(name string) {
+	ni := d.lockKnownNode(name)
+	if ni == nil {
+		return
+	}
+	defer ni.lock.Unlock()
+	klog.Infof("Remove node %s", name)
+	ni.Close()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.nodeMaps, name)
+}
+
+// nodeGPUsChanged reports whether the GPUs built from the node would differ from the ones built from
+// the old node, a node registered again is always rebuilt.
+func nodeGPUsChanged(old, node *v1.Node, core, mem v1.ResourceName) bool {
+	if old.UID != node.UID {
+		return true
+	}
+	for _, name := range []v1.ResourceName{core, mem, v1alpha1.ResourcePGPU} {
+		oldQuantity, newQuantity := old.Status.Allocatable[name], node.Status.Allocatable[name]
+		if oldQuantity.Cmp(newQuantity) != 0 {
+			return true
+		}
+	}
+	for _, key := range []string{schetypes.AnnotationEGPUInventory, schetypes.AnnotationEGPUTopology} {
+		if old.Annotations[key] != node.Annotations[key] {
+			return true
+		}
+	}
+	return old.Labels[schetypes.LabelEGPUModel] != node.Labels[schetypes.LabelEGPUModel]
+}
+
+// NewPGPUScheduler returns the scheduler of pgpu pods. They are scheduled by a GPU unit scheduler of
+// the gpushare resources, which accounts pgpu containers as whole GPUs, so that pgpu and gpushare
+// pods on a node are allocated from the same devices and never share one.
+func NewPGPUScheduler(config ElasticSchedulerConfig) (ResourceScheduler, error) {
+	return NewGPUUnitScheduler(config, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)
 }
 
 func BuildResourceSchedulers(modes []string, config ElasticSchedulerConfig) (map[v1.ResourceName]ResourceScheduler, error) {
 	sches := map[v1.ResourceName]ResourceScheduler{}
+	if config.Ledger == nil {
+		config.Ledger = NewDeviceLedger()
+	}
+	// pgpu and gpushare share one scheduler, see NewPGPUScheduler
+	var gpuScheduler ResourceScheduler
 	for _, m := range modes {
 		switch m {
 		case "pgpu":
-			// TODO:
-			//d, err := NewPGPUScheduler(config)
-			//if err != nil {
-			//	return nil, err
-			//}
-			//sches[v1alpha1.ResourcePGPU] = d
+			if gpuScheduler == nil {
+				d, err := NewPGPUScheduler(config)
+				if err != nil {
+					return nil, err
+				}
+				gpuScheduler = d
+			}
+			sches[v1alpha1.ResourcePGPU] = gpuScheduler
 		case "gpushare":
-			d, err := NewGPUUnitScheduler(config, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)
+			if gpuScheduler == nil {
+				d, err := NewGPUUnitScheduler(config, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)
+				if err != nil {
+					return nil, err
+				}
+				gpuScheduler = d
+			}
+			sches[v1alpha1.ResourceGPUCore] = gpuScheduler
+			sches[v1alpha1.ResourceGPUMemory] = gpuScheduler
+		case "qgpu":
+			d, err := NewGPUUnitScheduler(config, v1alpha1.ResourceQGPUCore, v1alpha1.ResourceQGPUMemory)
 			if err != nil {
 				return nil, err
 			}
-			sches[v1alpha1.ResourceGPUCore] = d
-			sches[v1alpha1.ResourceGPUMemory] = d
-			//case "qgpu":
-			//	d, err := NewGPUUnitScheduler(config, v1alpha1.ResourceQGPUCore, v1alpha1.ResourceQGPUMemory)
-			//	if err != nil {
-			//		return nil, err
-			//	}
-			//	sches[v1alpha1.ResourceQGPUCore] = d
-			//	sches[v1alpha1.ResourceQGPUMemory] = d
+			sches[v1alpha1.ResourceQGPUCore] = d
+			sches[v1alpha1.ResourceQGPUMemory] = d
 		}
 	}
 
 	return sches, nil
 }
 
+// GetResourceScheduler returns the scheduler of the resources requested by the pod. A pod requesting
+// resources of several schedulers is rejected, as their modes can't share its devices.
 func GetResourceScheduler(pod *v1.Pod, registeredSchedulers map[v1.ResourceName]ResourceScheduler) (ResourceScheduler, error) {
-	for _, c := range pod.Spec.Containers {
-		for k, _ := range c.Resources.Requests {
-			d := registeredSchedulers[k]
-			if d != nil {
-				return d, nil
+	requested := map[string]struct{}{}
+	for _, c := range GetPodContainers(pod) {
+		for k := range c.Resources.Requests {
+			if registeredSchedulers[k] != nil {
+				requested[string(k)] = struct{}{}
 			}
 		}
 	}
+	if len(requested) == 0 {
+		return nil, fmt.Errorf("cannot find scheduler for pod %s/%s", pod.Namespace, pod.Name)
+	}
+	names := make([]string, 0, len(requested))
+	for name := range requested {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	return nil, fmt.Errorf("cannot find scheduler for pod %s/%s", pod.Namespace, pod.Name)
+	d := registeredSchedulers[v1.ResourceName(names[0])]
+	for _, name := range names[1:] {
+		if registeredSchedulers[v1.ResourceName(name)] != d {
+			return nil, fmt.Errorf("pod %s/%s requests resources of different modes: %s", pod.Namespace, pod.Name, strings.Join(names, ", "))
+		}
+	}
+	return d, nil
 }
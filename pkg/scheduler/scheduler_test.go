@@ -41,3 +41,23 @@ func generatePods(namePrefix string, count int) []v1.Pod {
 
 	return pods
 }
+
+func TestScoreBound(t *testing.T) {
+	for score, want := range map[int]int{-5: ScoreMin, 0: ScoreMin, 55: 5, NormalizedScoreMax: ScoreMax, 150000: ScoreMax} {
+		if got := extenderScore(score); got != want {
+			t.Errorf("expected score %d mapped to %d, got %d", score, want, got)
+		}
+	}
+
+	node := newTopologyNode(2, "")
+	config := ElasticSchedulerConfig{Lister: newTestClusterLister(t, []*v1.Node{node}, nil), Rater: constRater(1000)}
+	d := &GPUUnitScheduler{BaseScheduler: newBaseScheduler(config, v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory)}
+	pod := newModePod("score", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+	large := newModePod("large", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "300", "8")
+	if scores := d.Score([]string{node.Name, "missing"}, &pod); scores[0] != ScoreMax || scores[1] != ScoreMin {
+		t.Fatalf("expected the scores bound by %d..%d, got %v", ScoreMin, ScoreMax, scores)
+	}
+	if scores := d.Score([]string{node.Name}, &large); scores[0] != ScoreMin {
+		t.Fatalf("expected a pod not fitting scored %d, got %v", ScoreMin, scores)
+	}
+}
@@ -0,0 +1,284 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// modeResources maps each mode to a resource its scheduler is registered for, see
+// BuildResourceSchedulers.
+var modeResources = map[string]v1.ResourceName{
+	"gpushare": v1alpha1.ResourceGPUCore,
+	"pgpu":     v1alpha1.ResourcePGPU,
+	"qgpu":     v1alpha1.ResourceQGPUCore,
+}
+
+// NodeAllocation is the allocation of the GPUs of a node by the scheduler of some modes. A node with
+// pods of several schedulers has an allocation by each of them.
+type NodeAllocation struct {
+	Name string `json:"name"`
+	// Modes lists the modes of the scheduler, gpushare and pgpu share one.
+	Modes  []string          `json:"modes"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Assumed is the number of pods assumed on the node and not bound yet.
+	Assumed int `json:"assumed"`
+	// Overcommitted lists the pods bound to the node whose allocations don't fit its GPUs.
+	Overcommitted []string        `json:"overcommitted,omitempty"`
+	GPUs          []GPUAllocation `json:"gpus"`
+}
+
+// GPUAllocation is the usage of a GPU of a node, with the pods on it.
+type GPUAllocation struct {
+	GPUStatus
+	UUID  string      `json:"uuid,omitempty"`
+	Model string      `json:"model,omitempty"`
+	Pods  []GPUTenant `json:"pods"`
+}
+
+// GPUTenant is a pod bound or assumed on a GPU.
+type GPUTenant struct {
+	// Pod is the namespace and name of the pod.
+	Pod string `json:"pod"`
+	// Containers lists the containers of a bound pod which are given the GPU.
+	Containers []string `json:"containers,omitempty"`
+	// Assumed is set if the pod is assumed on the node and not bound yet.
+	Assumed bool `json:"assumed,omitempty"`
+}
+
+// PodAllocation is the allocation of a pod, bound to a node or assumed on nodes.
+type PodAllocation struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	UID       types.UID `json:"uid"`
+	// Modes lists the modes of the scheduler of the pod.
+	Modes []string `json:"modes"`
+	// Node is the node the pod is bound to, empty if it's not bound yet.
+	Node string `json:"node,omitempty"`
+	// Containers lists the GPUs of each container of the bound pod.
+	Containers []ContainerAllocation `json:"containers,omitempty"`
+	// AssumedOn lists the nodes the pod is assumed on, until it's bound to one of them.
+	AssumedOn []string `json:"assumedOn,omitempty"`
+}
+
+// ContainerAllocation is the device indexes of the GPUs of a container.
+type ContainerAllocation struct {
+	Name string `json:"name"`
+	GPUs []int  `json:"gpus"`
+}
+
+// AllocationQuery selects the allocations served by the status API.
+type AllocationQuery struct {
+	// Mode selects the allocations of the scheduler of the mode, any if empty.
+	Mode string
+	// Selector selects nodes by their labels, any if nil.
+	Selector labels.Selector
+}
+
+// schedulers returns the sorted modes of each scheduler the query selects.
+func (q AllocationQuery) schedulers(sches map[v1.ResourceName]ResourceScheduler) (map[ResourceScheduler][]string, error) {
+	if _, ok := modeResources[q.Mode]; q.Mode != "" && !ok {
+		return nil, fmt.Errorf("unknown mode %s", q.Mode)
+	}
+	modes := make(map[ResourceScheduler][]string)
+	for mode, resource := range modeResources {
+		if d, ok := sches[resource]; ok {
+			modes[d] = append(modes[d], mode)
+		}
+	}
+	for d := range modes {
+		sort.Strings(modes[d])
+		if q.Mode != "" && !containsString(modes[d], q.Mode) {
+			delete(modes, d)
+		}
+	}
+	return modes, nil
+}
+
+func (q AllocationQuery) matches(a NodeAllocation) bool {
+	return q.Selector == nil || q.Selector.Matches(labels.Set(a.Labels))
+}
+
+// ListNodeAllocations returns the allocations of the known nodes the query selects, sorted by node.
+func ListNodeAllocations(sches map[v1.ResourceName]ResourceScheduler, query AllocationQuery) ([]NodeAllocation, error) {
+	modes, err := query.schedulers(sches)
+	if err != nil {
+		return nil, err
+	}
+	allocations := make([]NodeAllocation, 0)
+	for d, m := range modes {
+		for _, a := range d.Allocations() {
+			if query.matches(a) {
+				a.Modes = m
+				allocations = append(allocations, a)
+			}
+		}
+	}
+	sortNodeAllocations(allocations)
+	return allocations, nil
+}
+
+// GetNodeAllocations returns the allocations of the node the query selects, none if the node is not
+// known.
+func GetNodeAllocations(sches map[v1.ResourceName]ResourceScheduler, name string, query AllocationQuery) ([]NodeAllocation, error) {
+	modes, err := query.schedulers(sches)
+	if err != nil {
+		return nil, err
+	}
+	allocations := make([]NodeAllocation, 0)
+	for d, m := range modes {
+		if a, ok := d.NodeAllocation(name); ok && query.matches(a) {
+			a.Modes = m
+			allocations = append(allocations, a)
+		}
+	}
+	sortNodeAllocations(allocations)
+	return allocations, nil
+}
+
+// GetPodAllocation returns the allocation of the pod by the schedulers the query selects, false if
+// the pod is neither bound nor assumed.
+func GetPodAllocation(sches map[v1.ResourceName]ResourceScheduler, namespace, name string, query AllocationQuery) (PodAllocation, bool, error) {
+	modes, err := query.schedulers(sches)
+	if err != nil {
+		return PodAllocation{}, false, err
+	}
+	for d, m := range modes {
+		if a, ok := d.PodAllocation(namespace, name); ok {
+			a.Modes = m
+			return a, true, nil
+		}
+	}
+	return PodAllocation{}, false, nil
+}
+
+func sortNodeAllocations(allocations []NodeAllocation) {
+	sort.Slice(allocations, func(i, j int) bool {
+		if allocations[i].Name != allocations[j].Name {
+			return allocations[i].Name < allocations[j].Name
+		}
+		return allocations[i].Modes[0] < allocations[j].Modes[0]
+	})
+}
+
+// Allocation returns the allocation of the GPUs of the node, with the pods on each GPU.
+func (ni *NodeAllocator) Allocation() NodeAllocation {
+	status := ni.Status()
+	allocation := NodeAllocation{
+		Name:          ni.Node.Name,
+		Labels:        ni.Node.Labels,
+		Assumed:       status.Assumed,
+		Overcommitted: status.Overcommitted,
+		GPUs:          make([]GPUAllocation, len(ni.GPUs)),
+	}
+	for i, gpu := range ni.GPUs {
+		allocation.GPUs[i] = GPUAllocation{GPUStatus: status.GPUs[i], UUID: gpu.UUID, Model: gpu.Model, Pods: []GPUTenant{}}
+	}
+
+	pods := make([]v1.Pod, 0, len(ni.podsMap))
+	for _, pod := range ni.podsMap {
+		pods = append(pods, *pod)
+	}
+	sortPods(pods)
+	for i := range pods {
+		tenants := make(map[int][]string)
+		for _, c := range containerAllocations(&pods[i], ni.CoreName, ni.MemName) {
+			for _, index := range c.GPUs {
+				if position, ok := ni.GPUs.Position(index); ok && !containsString(tenants[position], c.Name) {
+					tenants[position] = append(tenants[position], c.Name)
+				}
+			}
+		}
+		for position, names := range tenants {
+			gpu := &allocation.GPUs[position]
+			gpu.Pods = append(gpu.Pods, GPUTenant{Pod: pods[i].Namespace + "/" + pods[i].Name, Containers: names})
+		}
+	}
+
+	assumed := make([]*assumption, 0, len(ni.assumed))
+	for _, a := range ni.assumed {
+		assumed = append(assumed, a)
+	}
+	sort.Slice(assumed, func(i, j int) bool {
+		return assumed[i].pod < assumed[j].pod
+	})
+	for _, a := range assumed {
+		for position, usage := range ni.GPUs.footprint(a.option) {
+			if !usage.empty() {
+				gpu := &allocation.GPUs[position]
+				gpu.Pods = append(gpu.Pods, GPUTenant{Pod: a.pod, Assumed: true})
+			}
+		}
+	}
+	return allocation
+}
+
+// Allocations returns the allocation of each known node.
+func (d *GPUUnitScheduler) Allocations() []NodeAllocation {
+	allocations := make([]NodeAllocation, 0)
+	d.eachNode(func(ni *NodeAllocator) {
+		allocations = append(allocations, ni.Allocation())
+	})
+	return allocations
+}
+
+// NodeAllocation returns the allocation of the node, false if the node is not known.
+func (d *GPUUnitScheduler) NodeAllocation(name string) (NodeAllocation, bool) {
+	ni := d.lockKnownNode(name)
+	if ni == nil {
+		return NodeAllocation{}, false
+	}
+	defer ni.lock.Unlock()
+	return ni.Allocation(), true
+}
+
+// PodAllocation returns the allocation of the pod, false if the pod is neither bound nor assumed. A
+// bound pod is found on its node, pods of other modes on the node are not allocated by the scheduler.
+func (d *GPUUnitScheduler) PodAllocation(namespace, name string) (PodAllocation, bool) {
+	allocation := PodAllocation{Namespace: namespace, Name: name}
+	key := namespace + "/" + name
+	found := false
+	d.eachNode(func(ni *NodeAllocator) {
+		for uid, pod := range ni.podsMap {
+			if pod.Namespace == namespace && pod.Name == name {
+				if containers := containerAllocations(pod, d.coreName, d.memName); len(containers) > 0 {
+					allocation.UID, allocation.Node, allocation.Containers = uid, ni.Node.Name, containers
+					found = true
+				}
+			}
+		}
+		for uid, a := range ni.assumed {
+			if a.pod == key {
+				allocation.UID = uid
+				allocation.AssumedOn = append(allocation.AssumedOn, ni.Node.Name)
+				found = true
+			}
+		}
+	})
+	sort.Strings(allocation.AssumedOn)
+	return allocation, found
+}
+
+// containerAllocations returns the GPUs of each container of the pod requesting the resources, from
+// the annotations of the pod.
+func containerAllocations(pod *v1.Pod, core, mem v1.ResourceName) []ContainerAllocation {
+	option, err := NewGPUOptionFromPod(pod, core, mem)
+	if err != nil {
+		return nil
+	}
+	var allocations []ContainerAllocation
+	for i, c := range GetPodContainers(pod) {
+		if i >= len(option.Allocated) || len(option.Allocated[i]) == 0 || option.Allocated[i][0] == NotNeedGPU {
+			continue
+		}
+		if unit := option.Request[i]; unit.Core == NotNeedGPU && unit.Memory == NotNeedGPU {
+			continue
+		}
+		allocations = append(allocations, ContainerAllocation{Name: c.Name, GPUs: option.Allocated[i]})
+	}
+	return allocations
+}
@@ -0,0 +1,82 @@
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestAllocations(t *testing.T) {
+	node := newMixedModeNode()
+	node.Labels = map[string]string{"pool": "training"}
+	share := newModePod("share", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "60", "8")
+	bound := GetUpdatedPodAnnotationSpec(&share, GPUIDs{{1}})
+	bound.Spec.NodeName = node.Name
+	qgpu := newModePod("qgpu", v1alpha1.ResourceQGPUCore, v1alpha1.ResourceQGPUMemory, "30", "4")
+	qgpuBound := GetUpdatedPodAnnotationSpec(&qgpu, GPUIDs{{0}})
+	qgpuBound.Spec.NodeName = node.Name
+	config := ElasticSchedulerConfig{
+		Lister: newTestClusterLister(t, []*v1.Node{node}, []*v1.Pod{bound, qgpuBound}),
+		Rater:  &Binpack{},
+	}
+	sches, err := BuildResourceSchedulers([]string{"gpushare", "pgpu", "qgpu"}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := newModePod("pending", v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, "20", "4")
+	if _, _, err := sches[v1alpha1.ResourceGPUCore].Assume([]string{node.Name}, &pending); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := ListNodeAllocations(sches, AllocationQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || !reflect.DeepEqual(all[0].Modes, []string{"gpushare", "pgpu"}) || !reflect.DeepEqual(all[1].Modes, []string{"qgpu"}) {
+		t.Fatalf("expected the node allocated by both schedulers, got %+v", all)
+	}
+	gpus := all[0].GPUs
+	want := []GPUTenant{{Pod: "/share-0", Containers: []string{"main"}}, {Pod: "/pending-0", Assumed: true}}
+	if all[0].Assumed != 1 || len(gpus[0].Pods) != 0 || !reflect.DeepEqual(gpus[1].Pods, want) || gpus[1].CoreAvailable != 20 {
+		t.Fatalf("expected the bound and assumed pods on gpu 1, got %+v", all[0])
+	}
+
+	qgpuOnly, err := ListNodeAllocations(sches, AllocationQuery{Mode: "qgpu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qgpuOnly) != 1 || qgpuOnly[0].GPUs[0].Pods[0].Pod != "/qgpu-0" {
+		t.Fatalf("expected the qgpu allocation only, got %+v", qgpuOnly)
+	}
+	selected, err := GetNodeAllocations(sches, node.Name, AllocationQuery{Mode: "pgpu", Selector: labels.SelectorFromSet(labels.Set{"pool": "training"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 1 || selected[0].Name != node.Name {
+		t.Fatalf("expected the node selected by its label, got %+v", selected)
+	}
+	if none, _ := ListNodeAllocations(sches, AllocationQuery{Selector: labels.SelectorFromSet(labels.Set{"pool": "serving"})}); len(none) != 0 {
+		t.Fatalf("expected no node selected, got %+v", none)
+	}
+	if _, err := ListNodeAllocations(sches, AllocationQuery{Mode: "vgpu"}); err == nil {
+		t.Fatal("expected an unknown mode rejected")
+	}
+
+	allocation, ok, err := GetPodAllocation(sches, "", "share-0", AllocationQuery{})
+	if err != nil || !ok {
+		t.Fatalf("expected the bound pod found, got %v, %v", ok, err)
+	}
+	if allocation.Node != node.Name || !reflect.DeepEqual(allocation.Containers, []ContainerAllocation{{Name: "main", GPUs: []int{1}}}) {
+		t.Fatalf("unexpected allocation of the bound pod %+v", allocation)
+	}
+	allocation, ok, _ = GetPodAllocation(sches, "", "pending-0", AllocationQuery{})
+	if !ok || allocation.Node != "" || !reflect.DeepEqual(allocation.AssumedOn, []string{node.Name}) || allocation.UID != pending.UID {
+		t.Fatalf("expected the pod assumed on the node, got %+v, %v", allocation, ok)
+	}
+	if _, ok, _ := GetPodAllocation(sches, "", "share-0", AllocationQuery{Mode: "qgpu"}); ok {
+		t.Fatal("expected the gpushare pod not found in qgpu mode")
+	}
+}
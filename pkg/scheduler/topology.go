@@ -0,0 +1,204 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+)
+
+// NoTopology marks an unknown topology domain of a GPU.
+const NoTopology = -1
+
+// GPUTopology is the interconnect topology of the GPUs on a node, written by the agent to the
+// elasticgpu.io/gpu-topology node annotation, e.g.
+//
+//	{"nvlinkGroups": [[0, 1, 2, 3], [4, 5, 6, 7]], "pcieSwitches": [[0, 1], [2, 3], [4, 5], [6, 7]], "numaNodes": [[0, 1, 2, 3], [4, 5, 6, 7]]}
+//
+// Each group lists the indexes of GPUs in the same domain.
+type GPUTopology struct {
+	NVLinkGroups [][]int `json:"nvlinkGroups,omitempty"`
+	PCIeSwitches [][]int `json:"pcieSwitches,omitempty"`
+	NUMANodes    [][]int `json:"numaNodes,omitempty"`
+}
+
+// GetNodeGPUTopology returns the topology in the node's annotation, or nil if the node doesn't
+// have one.
+func GetNodeGPUTopology(node *v1.Node) (*GPUTopology, error) {
+	value, ok := node.Annotations[utils.AnnotationEGPUTopology]
+	if !ok || value == "" {
+		return nil, nil
+	}
+	topology := &GPUTopology{}
+	if err := json.Unmarshal([]byte(value), topology); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation of node %s: %v", utils.AnnotationEGPUTopology, node.Name, err)
+	}
+	return topology, nil
+}
+
+// Apply sets the topology domains of the GPUs, GPUs missing from a level are left unknown. Groups
+// list device indexes.
+func (t *GPUTopology) Apply(g GPUs) error {
+	levels := []struct {
+		name   string
+		groups [][]int
+		set    func(gpu *GPU, group int)
+	}{
+		{"nvlinkGroups", t.NVLinkGroups, func(gpu *GPU, group int) { gpu.NVLinkGroup = group }},
+		{"pcieSwitches", t.PCIeSwitches, func(gpu *GPU, group int) { gpu.PCIeSwitch = group }},
+		{"numaNodes", t.NUMANodes, func(gpu *GPU, group int) { gpu.NUMANode = group }},
+	}
+	for _, level := range levels {
+		for _, indexes := range level.groups {
+			for _, i := range indexes {
+				if _, ok := g.Position(i); !ok {
+					return fmt.Errorf("gpu index %d in %s is not found on the node", i, level.name)
+				}
+			}
+		}
+	}
+	for _, gpu := range g {
+		gpu.NVLinkGroup, gpu.PCIeSwitch, gpu.NUMANode = NoTopology, NoTopology, NoTopology
+	}
+	for _, level := range levels {
+		for group, indexes := range level.groups {
+			for _, i := range indexes {
+				position, _ := g.Position(i)
+				level.set(g[position], group)
+			}
+		}
+	}
+	return nil
+}
+
+type topologyLevel func(gpu *GPU) int
+
+var topologyLevels = []topologyLevel{
+	func(gpu *GPU) int { return gpu.NVLinkGroup },
+	func(gpu *GPU) int { return gpu.PCIeSwitch },
+	func(gpu *GPU) int { return gpu.NUMANode },
+}
+
+// SelectWholeGPUs chooses count GPUs out of free with the best connectivity. From the NVLink level
+// down to the NUMA level, the GPUs are taken from the smallest domain that holds all of them, so
+// that larger domains stay available for larger requests; when no domain is large enough, they are
+// taken from the largest domains first. GPUs with unknown domains are considered as connected to
+// each other. With sameNVLink, the GPUs must be in the same known NVLink domain.
+func (g GPUs) SelectWholeGPUs(free []int, count int, sameNVLink bool) ([]int, error) {
+	if len(free) < count {
+		return nil, fmt.Errorf("no enough free gpus, need %d, free %d", count, len(free))
+	}
+	if sameNVLink && count > 1 {
+		var domain []int
+		for _, group := range g.groupBy(free, topologyLevels[0]) {
+			if g[group[0]].NVLinkGroup != NoTopology && len(group) >= count && (domain == nil || len(group) < len(domain)) {
+				domain = group
+			}
+		}
+		if domain == nil {
+			return nil, fmt.Errorf("no nvlink domain with %d free gpus", count)
+		}
+		return g.selectWholeGPUs(domain, count, 1), nil
+	}
+	return g.selectWholeGPUs(free, count, 0), nil
+}
+
+func (g GPUs) selectWholeGPUs(free []int, count int, level int) []int {
+	if level == len(topologyLevels) || count == 0 {
+		return append([]int(nil), free[:count]...)
+	}
+	groups := g.groupBy(free, topologyLevels[level])
+	var fit []int
+	for _, group := range groups {
+		if len(group) >= count && (fit == nil || len(group) < len(fit)) {
+			fit = group
+		}
+	}
+	if fit != nil {
+		return g.selectWholeGPUs(fit, count, level+1)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i]) > len(groups[j]) })
+	selected := make([]int, 0, count)
+	for _, group := range groups {
+		n := count - len(selected)
+		if n > len(group) {
+			n = len(group)
+		}
+		selected = append(selected, g.selectWholeGPUs(group, n, level+1)...)
+		if len(selected) == count {
+			break
+		}
+	}
+	sort.Ints(selected)
+	return selected
+}
+
+// groupBy splits indexes by the domain of the level, keeping the order of first appearance.
+func (g GPUs) groupBy(indexes []int, level topologyLevel) [][]int {
+	groups := make([][]int, 0)
+	position := make(map[int]int)
+	for _, i := range indexes {
+		domain := level(g[i])
+		p, ok := position[domain]
+		if !ok {
+			p = len(groups)
+			position[domain] = p
+			groups = append(groups, nil)
+		}
+		groups[p] = append(groups[p], i)
+	}
+	return groups
+}
+
+const (
+	linkScoreNVLink     = 3
+	linkScorePCIeSwitch = 2
+	linkScoreNUMANode   = 1
+)
+
+// linkScore rates the connection between two GPUs, GPUs without any known domain are considered
+// as well connected so that the rater is neutral on nodes without topology.
+func linkScore(a, b *GPU) int {
+	switch {
+	case !a.hasTopology() || !b.hasTopology():
+		return linkScoreNVLink
+	case a.NVLinkGroup != NoTopology && a.NVLinkGroup == b.NVLinkGroup:
+		return linkScoreNVLink
+	case a.PCIeSwitch != NoTopology && a.PCIeSwitch == b.PCIeSwitch:
+		return linkScorePCIeSwitch
+	case a.NUMANode != NoTopology && a.NUMANode == b.NUMANode:
+		return linkScoreNUMANode
+	}
+	return 0
+}
+
+func (g *GPU) hasTopology() bool {
+	return g.NVLinkGroup != NoTopology || g.PCIeSwitch != NoTopology || g.NUMANode != NoTopology
+}
+
+// Topology prefers options which place the containers of a pod on well connected GPUs.
+type Topology struct {
+}
+
+func (t *Topology) Rate(g GPUs, indexes []int) int {
+	distinct := make([]int, 0, len(indexes))
+	for _, i := range indexes {
+		if i >= 0 && i < len(g) && !containsInt(distinct, i) {
+			distinct = append(distinct, i)
+		}
+	}
+	if len(distinct) < 2 {
+		return NormalizedScoreMax
+	}
+	score, pairs := 0, 0
+	for a := 0; a < len(distinct); a++ {
+		for b := a + 1; b < len(distinct); b++ {
+			score += linkScore(g[distinct[a]], g[distinct[b]])
+			pairs++
+		}
+	}
+	return score * NormalizedScoreMax / (pairs * linkScoreNVLink)
+}
@@ -0,0 +1,182 @@
+package scheduler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"elasticgpu.io/elastic-gpu-scheduler/pkg/utils"
+	"elasticgpu.io/elastic-gpu/apis/elasticgpu/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// dgxTopology has two NVLink domains of four GPUs, each with two PCIe switches, on two NUMA nodes.
+const dgxTopology = `{"nvlinkGroups": [[0, 1, 2, 3], [4, 5, 6, 7]], "pcieSwitches": [[0, 1], [2, 3], [4, 5], [6, 7]], "numaNodes": [[0, 1, 2, 3], [4, 5, 6, 7]]}`
+
+func newTopologyNode(gpuCount int, topology string) *v1.Node {
+	node := &v1.Node{
+		Status: v1.NodeStatus{
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1alpha1.ResourceGPUCore:   *resource.NewQuantity(int64(gpuCount*utils.GPUCoreEachCard), resource.DecimalSI),
+				v1alpha1.ResourceGPUMemory: *resource.NewQuantity(int64(gpuCount*16), resource.DecimalSI),
+			},
+		},
+	}
+	node.Name = "topology"
+	if topology != "" {
+		node.Annotations = map[string]string{utils.AnnotationEGPUTopology: topology}
+	}
+	return node
+}
+
+func TestSelectWholeGPUs(t *testing.T) {
+	tests := []struct {
+		name       string
+		topology   string
+		used       []int
+		count      int
+		sameNVLink bool
+		want       []int
+		wantErr    bool
+	}{
+		{
+			name:  "no topology takes the first free gpus",
+			used:  []int{1},
+			count: 2,
+			want:  []int{0, 2},
+		},
+		{
+			name:     "pair behind the same pcie switch",
+			topology: dgxTopology,
+			used:     []int{0},
+			count:    2,
+			want:     []int{2, 3},
+		},
+		{
+			name:     "smallest nvlink domain that fits",
+			topology: dgxTopology,
+			used:     []int{0, 5},
+			count:    3,
+			want:     []int{1, 2, 3},
+		},
+		{
+			name:     "spans nvlink domains starting from the largest",
+			topology: dgxTopology,
+			used:     []int{0, 1, 4},
+			count:    5,
+			want:     []int{2, 3, 5, 6, 7},
+		},
+		{
+			name:       "same nvlink required and available",
+			topology:   dgxTopology,
+			used:       []int{0},
+			count:      4,
+			sameNVLink: true,
+			want:       []int{4, 5, 6, 7},
+		},
+		{
+			name:       "same nvlink required but split",
+			topology:   dgxTopology,
+			used:       []int{0, 4},
+			count:      4,
+			sameNVLink: true,
+			wantErr:    true,
+		},
+		{
+			name:       "same nvlink required without topology",
+			count:      2,
+			sameNVLink: true,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ni, err := NewNodeAllocator(nil, newTopologyNode(8, tt.topology), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, i := range tt.used {
+				ni.GPUs[i].Add(GPUUnit{GPUCount: 1})
+			}
+			got, err := ni.GPUs.SelectWholeGPUs(ni.GPUs.GetFreeGPUs(), tt.count, tt.sameNVLink)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected gpus %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAssumeSameNVLink(t *testing.T) {
+	ni, err := NewNodeAllocator(nil, newTopologyNode(8, dgxTopology), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ni.GPUs[1].Add(GPUUnit{GPUCount: 1})
+	ni.GPUs[6].Add(GPUUnit{GPUCount: 1})
+
+	pod := generatePods("nvlink", 1)[0]
+	pod.Spec.Containers[0].Resources.Requests[v1alpha1.ResourceGPUCore] = resource.MustParse("400")
+	pod.Annotations = map[string]string{utils.AnnotationEGPUNVLink: utils.NVLinkRequired}
+	_, err = ni.Assume(&pod)
+	if err == nil || !strings.Contains(err.Error(), "nvlink") {
+		t.Fatalf("expected nvlink error, got %v", err)
+	}
+
+	delete(pod.Annotations, utils.AnnotationEGPUNVLink)
+	ids, err := ni.Assume(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// three GPUs of the first NVLink domain and the one of the second left alone on its switch
+	if !reflect.DeepEqual(ids, GPUIDs{{0, 2, 3, 7}}) {
+		t.Fatalf("unexpected gpus %v", ids)
+	}
+}
+
+func TestTopologyRate(t *testing.T) {
+	ni, err := NewNodeAllocator(nil, newTopologyNode(8, dgxTopology), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Topology{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rater := &Topology{}
+	tests := []struct {
+		indexes []int
+		want    int
+	}{
+		{[]int{0, 0}, NormalizedScoreMax},
+		{[]int{0, 1}, NormalizedScoreMax},
+		{[]int{0, 4}, ScoreMin},
+		{[]int{0, 1, 4}, NormalizedScoreMax / 3},
+		{[]int{NotNeedRate, 3}, NormalizedScoreMax},
+	}
+	for _, tt := range tests {
+		if got := rater.Rate(ni.GPUs, tt.indexes); got != tt.want {
+			t.Errorf("Rate(%v) = %d, want %d", tt.indexes, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidTopologyIgnored(t *testing.T) {
+	for _, topology := range []string{"{", `{"nvlinkGroups": [[0, 8]]}`} {
+		ni, err := NewNodeAllocator(nil, newTopologyNode(8, topology), v1alpha1.ResourceGPUCore, v1alpha1.ResourceGPUMemory, &Binpack{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, gpu := range ni.GPUs {
+			if gpu.hasTopology() {
+				t.Fatalf("expected topology %s to be ignored, gpu %d: %+v", topology, i, gpu)
+			}
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package scheduler
+
+import (
+	"fmt"
+
+	"k8s.io/klog/v2"
+)
+
+// TradeBudget bounds the search of Trade on a node.
+type TradeBudget struct {
+	// Steps is the max number of partial allocations explored on a node, 0 means unlimited.
+	// When the budget runs out the best option found so far is returned. The search order
+	// only depends on the GPUs and the request, so the result is deterministic.
+	Steps int
+}
+
+var DefaultTradeBudget = TradeBudget{Steps: 10000}
+
+// BoundedRater is implemented by raters which can give an upper bound of the score of any
+// complete allocation extending a partial one, which lets Trade skip branches that can't beat
+// the best option found so far.
+type BoundedRater interface {
+	Rater
+	// Bound returns an upper bound of the scores of the allocations which place remaining on g,
+	// after the containers rated by indexes have been placed.
+	Bound(g GPUs, indexes []int, remaining GPURequest) int
+}
+
+// Trade finds the best option of the request on the GPUs with DefaultTradeBudget.
+func (g GPUs) Trade(rater Rater, request GPURequest) (*GPUOption, error) {
+	return g.TradeWithBudget(rater, request, DefaultTradeBudget)
+}
+
+// TradeWithBudget searches the options of the request depth first, container by container:
+//   - GPUs in the same state are interchangeable for a container, only the first of them is tried;
+//   - GPUs are tried from the best rated partial allocation, so good options are found early;
+//   - whole GPUs are chosen by their topology, see SelectWholeGPUs, before the share of a container
+//     which also needs one;
+//   - if the rater is a BoundedRater, branches which can't beat the best option are pruned;
+//   - the search stops when the budget is exhausted.
+//
+// Of the options with the same score the first one found is kept. Init containers are placed on
+// the best option afterwards, see placeInit.
+func (g GPUs) TradeWithBudget(rater Rater, request GPURequest, budget TradeBudget) (option *GPUOption, err error) {
+	var (
+		dfs         func(i int)
+		apps        = request.appCount()
+		indexes     = make([][]int, apps)
+		rateIndexes = make([]int, apps)
+		found       = false
+		steps       = 0
+		exhausted   = false
+		reason      error
+	)
+	bounded, canBound := rater.(BoundedRater)
+	option = NewGPUOption(request)
+
+	// place records the GPUs of a container, the container is rated by its shared GPU if it has
+	// one, whole GPUs are not rated
+	place := func(containerIndex int, gpuIndexes []int, shared int) {
+		indexes[containerIndex] = gpuIndexes
+		rateIndexes[containerIndex] = shared
+	}
+
+	dfs = func(containerIndex int) {
+		if budget.Steps > 0 && steps >= budget.Steps {
+			exhausted = true
+			return
+		}
+		steps++
+		if containerIndex == apps {
+			currScore := rater.Rate(g, rateIndexes)
+			if found && currScore <= option.Score {
+				return
+			}
+			found = true
+			for i, gpuIndex := range indexes {
+				option.Allocated[i] = append([]int(nil), gpuIndex...)
+			}
+			option.Score = currScore
+			return
+		}
+		if found && canBound && bounded.Bound(g, rateIndexes[:containerIndex], request[containerIndex:apps]) <= option.Score {
+			return
+		}
+
+		unit := request[containerIndex]
+		if klog.V(5).Enabled() {
+			klog.Infof("Start to allocate request on %d container: %+v, current gpus: %+v", containerIndex, unit, g)
+		}
+		if unit.Core == NotNeedGPU && unit.Memory == NotNeedGPU {
+			place(containerIndex, []int{NotNeedGPU}, NotNeedRate)
+			dfs(containerIndex + 1)
+			return
+		}
+		var whole []int
+		if unit.GPUCount > 0 {
+			wholeUnit := unit.wholePart()
+			selected, err := g.SelectWholeGPUs(g.allocatableGPUs(wholeUnit), unit.GPUCount, unit.SameNVLink)
+			if err != nil {
+				reason = err
+				return
+			}
+			if !unit.wholeMemoryFits(g, selected) {
+				reason = fmt.Errorf("memory %d doesn't fit in %d whole gpus", unit.Memory, unit.GPUCount)
+				return
+			}
+			for _, gpuIndex := range selected {
+				g[gpuIndex].Add(wholeUnit)
+			}
+			defer func() {
+				for _, gpuIndex := range selected {
+					g[gpuIndex].Sub(wholeUnit)
+				}
+			}()
+			if !unit.hasShare() {
+				place(containerIndex, selected, NotNeedRate)
+				dfs(containerIndex + 1)
+				return
+			}
+			whole, unit = selected, unit.sharePart(g, selected)
+		}
+
+		candidates := g.candidates(rater, unit, rateIndexes[:containerIndex+1])
+		if containerIndex == apps-1 && len(candidates) > 0 {
+			// the candidates of the last container are rated as complete options already
+			candidates = candidates[:1]
+		}
+		for _, i := range candidates {
+			g[i].Add(unit)
+			place(containerIndex, append(append([]int(nil), whole...), i), i)
+			dfs(containerIndex + 1)
+			g[i].Sub(unit)
+			if exhausted {
+				return
+			}
+		}
+	}
+	dfs(0)
+	if exhausted {
+		klog.V(4).Infof("Trade of request %s stopped after %d steps, found: %v", request, steps, found)
+	}
+	if !found {
+		if reason != nil {
+			return nil, fmt.Errorf("no enough resource to allocate: %v", reason)
+		}
+		return nil, fmt.Errorf("no enough resource to allocate")
+	}
+	if err := g.placeInit(rater, option); err != nil {
+		return nil, fmt.Errorf("no enough resource to allocate: %v", err)
+	}
+	return option, nil
+}
+
+// candidates returns the GPUs to try for a fractional unit, one for each distinct GPU state which
+// can hold the unit, best rated first. The last element of rateIndexes is overwritten while rating.
+func (g GPUs) candidates(rater Rater, unit GPUUnit, rateIndexes []int) []int {
+	states := make([]gpuState, 0, len(g))
+	indexes := make([]int, 0, len(g))
+	scores := make([]int, 0, len(g))
+	last := len(rateIndexes) - 1
+	for i, gpu := range g {
+		if !gpu.CanAllocate(unit) {
+			continue
+		}
+		state := gpu.state()
+		if containsState(states, state) {
+			continue
+		}
+		states = append(states, state)
+		gpu.Add(unit)
+		rateIndexes[last] = i
+		score := rater.Rate(g, rateIndexes)
+		gpu.Sub(unit)
+
+		// insertion keeps the candidates sorted by score, and by index for the same score
+		pos := len(scores)
+		for pos > 0 && scores[pos-1] < score {
+			pos--
+		}
+		scores = append(scores, 0)
+		indexes = append(indexes, 0)
+		copy(scores[pos+1:], scores[pos:])
+		copy(indexes[pos+1:], indexes[pos:])
+		scores[pos] = score
+		indexes[pos] = i
+	}
+	return indexes
+}
+
+func containsState(states []gpuState, state gpuState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package scheduler
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// exhaustiveTrade is the plain depth first search over every GPU for every container, it's the
+// reference the pruned search is checked and benchmarked against.
+func exhaustiveTrade(g GPUs, rater Rater, request GPURequest) (int, bool) {
+	indexes := make([]int, len(request))
+	best, found := 0, false
+	var dfs func(c int)
+	dfs = func(c int) {
+		if c == len(request) {
+			if score := rater.Rate(g, indexes); !found || score > best {
+				best, found = score, true
+			}
+			return
+		}
+		for i, gpu := range g {
+			if !gpu.CanAllocate(request[c]) {
+				continue
+			}
+			gpu.Add(request[c])
+			indexes[c] = i
+			dfs(c + 1)
+			gpu.Sub(request[c])
+		}
+	}
+	dfs(0)
+	return best, found
+}
+
+// newLoadedGPUs returns count GPUs, the used core and memory of each follow a fixed pattern so
+// that some of them are in the same state.
+func newLoadedGPUs(count int) GPUs {
+	gpus := make(GPUs, count)
+	for i := range gpus {
+		gpus[i] = newGPU(100, 16, 100, 16, 0)
+		if used := (i * 37) % 5; used > 0 {
+			gpus[i].Add(GPUUnit{Core: used * 15, Memory: used * 2})
+		}
+	}
+	return gpus
+}
+
+func newPodRequest(containers int) GPURequest {
+	request := make(GPURequest, containers)
+	for i := range request {
+		request[i] = GPUUnit{Core: 10 + 5*(i%3), Memory: 1 + i%2}
+	}
+	return request
+}
+
+func TestTradeFindsBestScore(t *testing.T) {
+	raters := map[string]Rater{
+		"binpack":       &Binpack{},
+		"spread":        &Spread{},
+		"fragmentation": &Fragmentation{},
+	}
+	for name, rater := range raters {
+		for _, gpuCount := range []int{1, 4, 8, 16} {
+			for _, containers := range []int{1, 2, 3, 4} {
+				t.Run(fmt.Sprintf("%s/%d-gpus/%d-containers", name, gpuCount, containers), func(t *testing.T) {
+					gpus := newLoadedGPUs(gpuCount)
+					request := newPodRequest(containers)
+					want, found := exhaustiveTrade(gpus, rater, request)
+					before := gpus.String()
+
+					option, err := gpus.TradeWithBudget(rater, request, TradeBudget{})
+					if !found {
+						if err == nil {
+							t.Fatalf("expected no option, got %+v", option)
+						}
+						return
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					if option.Score != want {
+						t.Fatalf("expected best score %d, got %d with %v", want, option.Score, option.Allocated)
+					}
+					if after := gpus.String(); after != before {
+						t.Fatalf("trade changed gpus from %s to %s", before, after)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestTradeBudget(t *testing.T) {
+	gpus := newLoadedGPUs(16)
+	request := newPodRequest(6)
+
+	first, err := gpus.TradeWithBudget(&Binpack{}, request, TradeBudget{Steps: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := gpus.TradeWithBudget(&Binpack{}, request, TradeBudget{Steps: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("expected the same option with the same budget, got %+v and %+v", first, second)
+	}
+
+	full, err := gpus.TradeWithBudget(&Binpack{}, request, TradeBudget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Score > full.Score {
+		t.Fatalf("budgeted search scored %d, more than the full search %d", first.Score, full.Score)
+	}
+}
+
+func TestTradeNotNeedGPU(t *testing.T) {
+	gpus := GPUs{newGPU(100, 16, 100, 16, 0)}
+	request := GPURequest{{Core: NotNeedGPU, Memory: NotNeedGPU}, {Core: 20, Memory: 2}}
+
+	option, err := gpus.Trade(&Spread{}, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(option.Allocated, [][]int{{NotNeedGPU}, {0}}) {
+		t.Fatalf("unexpected allocation %v", option.Allocated)
+	}
+	if err := gpus.Transact(option); err != nil {
+		t.Fatal(err)
+	}
+	if gpus[0].CoreAvailable != 80 || gpus[0].MemoryAvailable != 14 || gpus[0].Tenants != 1 {
+		t.Fatalf("unexpected gpu after transaction %+v", gpus[0])
+	}
+}
+
+func benchmarkTrade(b *testing.B, gpuCount, containers int, exhaustive bool) {
+	gpus := newLoadedGPUs(gpuCount)
+	request := newPodRequest(containers)
+	rater := &Spread{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if exhaustive {
+			exhaustiveTrade(gpus, rater, request)
+		} else if _, err := gpus.Trade(rater, request); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTrade8GPUs4Containers(b *testing.B)            { benchmarkTrade(b, 8, 4, false) }
+func BenchmarkTrade8GPUs4ContainersExhaustive(b *testing.B)  { benchmarkTrade(b, 8, 4, true) }
+func BenchmarkTrade16GPUs4Containers(b *testing.B)           { benchmarkTrade(b, 16, 4, false) }
+func BenchmarkTrade16GPUs4ContainersExhaustive(b *testing.B) { benchmarkTrade(b, 16, 4, true) }
+func BenchmarkTrade16GPUs6Containers(b *testing.B)           { benchmarkTrade(b, 16, 6, false) }
@@ -4,14 +4,25 @@ const (
 	NotNeedGPU      = -1
 	NodeNameField   = "spec.nodeName"
 	GPUCoreEachCard = 100
+	// GPUMemoryUnit is the canonical unit of gpu memory in bytes, 1Mi.
+	GPUMemoryUnit = 1024 * 1024
 
 	EGPUAssumed                   = "elasticgpu.io/assumed"
 	AnnotationEGPUContainerPrefix = "elasticgpu.io/container-"
 	AnnotationEGPUContainer       = "elasticgpu.io/container-%s"
+	AnnotationEGPUPolicy          = "elasticgpu.io/policy"
+	AnnotationEGPUTopology        = "elasticgpu.io/gpu-topology"
+	AnnotationEGPUInventory       = "elasticgpu.io/gpu-inventory"
+	AnnotationEGPUModel           = "elasticgpu.io/gpu-model"
+	AnnotationEGPUMinMemory       = "elasticgpu.io/gpu-min-memory"
+	LabelEGPUModel                = "elasticgpu.io/gpu-model"
+	AnnotationEGPUNVLink          = "elasticgpu.io/nvlink"
+	NVLinkRequired                = "required"
 
-	PriorityBinPack string = "binpack"
-	PrioritySpread  string = "spread"
+	PriorityBinPack       string = "binpack"
+	PrioritySpread        string = "spread"
+	PriorityFragmentation string = "fragmentation"
+	PriorityTopology      string = "topology"
 
-	OptimisticLockErrorMsg       = "the object has been modified; please apply your changes to the latest version and try again"
 	RecommendedKubeConfigPathEnv = "KUBECONFIG"
 )